@@ -4,13 +4,18 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"time"
+	"path/filepath"
 
 	"github.com/MickDuprez/gobase/core/auth"
 	"github.com/MickDuprez/gobase/core/config"
 	"github.com/MickDuprez/gobase/core/database"
+	"github.com/MickDuprez/gobase/core/events"
+	"github.com/MickDuprez/gobase/core/flags"
 	"github.com/MickDuprez/gobase/core/interfaces"
+	"github.com/MickDuprez/gobase/core/jobs"
+	"github.com/MickDuprez/gobase/core/mail"
 	"github.com/MickDuprez/gobase/core/middleware"
+	"github.com/MickDuprez/gobase/core/schedule"
 	"github.com/MickDuprez/gobase/core/template"
 )
 
@@ -18,9 +23,23 @@ type Application struct {
 	templates      *template.Manager
 	mux            *http.ServeMux
 	features       map[string]*interfaces.Feature
+	registered     []*interfaces.Feature // in dependency order once initialized
+	initialized    bool
+	featureConfig  *config.FeaturesConfig
+	disabled       []*interfaces.Feature // registered but turned off in config
 	auth           *auth.AuthDB
 	securityConfig *middleware.SecurityConfig
 	db             *database.DB
+	mailer         mail.Mailer
+	flags          *flags.Store
+	events         *events.Bus
+	jobs           *jobs.Queue
+	scheduler      *schedule.Scheduler
+	routes         map[string]string // route name to path pattern
+	middleware     []interfaces.Middleware
+	handler        http.HandlerFunc // the mux wrapped in middleware
+	dev            bool
+	server         *config.ServerConfig
 }
 
 func (app *Application) SessionSetValue(r *http.Request, key string, value interface{}) error {
@@ -95,13 +114,56 @@ func (app *Application) DB() *database.DB {
 	return app.db
 }
 
+func (app *Application) Mailer() mail.Mailer {
+	return app.mailer
+}
+
+func (app *Application) Events() *events.Bus {
+	return app.events
+}
+
+func (app *Application) Jobs() *jobs.Queue {
+	return app.jobs
+}
+
+func (app *Application) Scheduler() *schedule.Scheduler {
+	return app.scheduler
+}
+
 func New(cfg *config.AppConfig) (*Application, error) {
 	// Initialize auth
-	authDB, err := auth.NewAuthDB()
+	authDB, err := auth.NewAuthDB(cfg.AuthConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize auth: %w", err)
 	}
 
+	// Lets features react to each other, and to auth
+	bus := events.New()
+	authDB.SetEvents(bus)
+
+	// Which features are enabled and their settings
+	if err := cfg.Features.Load(); err != nil {
+		return nil, err
+	}
+
+	// Runtime feature flags
+	flagStore, err := flags.New(filepath.Join("data", "flags.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize flags: %w", err)
+	}
+
+	// Deferred work, run by the workers once the app starts
+	jobQueue, err := jobs.New(filepath.Join("data", "jobs.db"), cfg.JobsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize jobs: %w", err)
+	}
+
+	// Recurring tasks, features add theirs in OnInit
+	scheduler, err := schedule.New(filepath.Join("data", "schedule.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize scheduler: %w", err)
+	}
+
 	// Initialize template manager
 	tm, err := template.New()
 	if err != nil {
@@ -123,8 +185,41 @@ func New(cfg *config.AppConfig) (*Application, error) {
 		auth:           authDB,
 		db:             db, // Might be nil!
 		securityConfig: cfg.SecConfig,
+		mailer:         mail.New(cfg.MailConfig),
+		flags:          flagStore,
+		events:         bus,
+		jobs:           jobQueue,
+		scheduler:      scheduler,
+		routes:         make(map[string]string),
+		dev:            cfg.Server.IsDev,
+		server:         cfg.Server,
+		featureConfig:  cfg.Features,
 	}
 
+	// Every request gets an ID and is logged, every response the security
+	// headers, and a panic in a handler still gets a response. Apps add their
+	// own with Use.
+	app.Use(
+		middleware.RequestID,
+		middleware.Logger,
+		middleware.Recover(app.recovered),
+		middleware.SecurityHeaders(app.securityConfig),
+	)
+
+	// auth's middleware and handlers answer with the app's error pages
+	auth.WriteError = app.Error
+
+	// named routes for the url template helper
+	tm.SetRouteResolver(app.URL)
+
+	// {{if flag $ "name"}} in templates
+	tm.RegisterHelperFunc("flag", app.flagHelper)
+
+	// Lets the layout show a banner while an admin is impersonating a user
+	tm.RegisterLayoutData("Impersonation", func(r *http.Request) interface{} {
+		return authDB.ImpersonationFor(r)
+	})
+
 	// Add static file server
 	fileServer := http.FileServer(http.Dir("static"))
 	app.mux.Handle("GET /static/", http.StripPrefix("/static/", fileServer))
@@ -133,23 +228,25 @@ func New(cfg *config.AppConfig) (*Application, error) {
 }
 
 func (app *Application) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	sw := &statusWriter{ResponseWriter: w}
+	app.handler(w, r)
+}
 
-	app.mux.ServeHTTP(sw, r)
+// Use adds middleware run on every request, including static files and
+// requests that match no route. They run in the order they were added, after
+// the defaults set up by New. Call it before the app starts serving.
+func (app *Application) Use(mw ...interfaces.Middleware) {
+	app.middleware = append(app.middleware, mw...)
 
-	log.Printf(
-		"%s %s %d %v",
-		r.Method,
-		r.URL.Path,
-		sw.status,
-		time.Since(start),
-	)
+	handler := app.serveMux
+	for i := len(app.middleware) - 1; i >= 0; i-- {
+		handler = app.middleware[i](handler)
+	}
+	app.handler = handler
 }
 
-func (app *Application) Handle(pattern string, handler http.HandlerFunc) {
-	secureHandler := middleware.SecurityHeaders(app.securityConfig)(handler)
-	app.mux.HandleFunc(pattern, secureHandler)
+func (app *Application) Handle(pattern string, handler http.HandlerFunc) interfaces.Route {
+	app.mux.HandleFunc(pattern, handler)
+	return &route{app: app, pattern: pattern}
 }
 
 func (app *Application) RenderTemplate(w http.ResponseWriter, r *http.Request, feature, page string, data interface{}) error {
@@ -164,34 +261,8 @@ func (app *Application) RegisterHelperFunc(name string, fn interface{}) {
 	app.templates.RegisterHelperFunc(name, fn)
 }
 
-func (app *Application) RegisterFeature(f interfaces.Feature) error {
-	// Register feature's templates
-	if err := app.templates.RegisterFeature(f.Name, f.Path, f.NavItems...); err != nil {
-		return err
-	}
-
-	// Set up feature's routes
-	f.Routes(app)
-
-	app.features[f.Name] = &f
-	return nil
-}
-
-type statusWriter struct {
-	http.ResponseWriter
-	status int
-}
-
-func (w *statusWriter) WriteHeader(status int) {
-	w.status = status
-	w.ResponseWriter.WriteHeader(status)
-}
-
-func (w *statusWriter) Write(b []byte) (int, error) {
-	if w.status == 0 {
-		w.status = 200
-	}
-	return w.ResponseWriter.Write(b)
+func (app *Application) RegisterLayoutData(name string, fn func(r *http.Request) interface{}) {
+	app.templates.RegisterLayoutData(name, fn)
 }
 
 func (app *Application) Auth() *auth.AuthDB {
@@ -201,3 +272,13 @@ func (app *Application) Auth() *auth.AuthDB {
 func (app *Application) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return app.auth.RequireAuth(next)
 }
+
+func (app *Application) RequireToken(next http.HandlerFunc) http.HandlerFunc {
+	return app.auth.RequireToken(next)
+}
+
+// RequirePermission only lets through users whose roles grant perm, it
+// includes RequireAuth
+func (app *Application) RequirePermission(perm string, next http.HandlerFunc) http.HandlerFunc {
+	return app.auth.RequireAuth(app.auth.RequirePermission(perm, next))
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/MickDuprez/gobase/core/middleware"
+)
+
+//go:embed debug.html
+var debugHTML string
+
+var debugTemplate = template.Must(template.New("debug").Parse(debugHTML))
+
+const (
+	debugFrames       = 20 // frames shown, the full stack is also on the page
+	debugSourceFrames = 5  // app frames to show the source around
+	debugSourceLines  = 5  // lines either side of the call
+)
+
+type debugPage struct {
+	Value      string
+	Type       string
+	RequestID  string
+	Method     string
+	URL        string
+	Proto      string
+	RemoteAddr string
+	Headers    []debugHeader
+	Frames     []debugFrame
+	Stack      string
+}
+
+type debugHeader struct {
+	Name  string
+	Value string
+}
+
+type debugFrame struct {
+	middleware.Frame
+	Source []sourceLine
+}
+
+type sourceLine struct {
+	Number  int
+	Text    string
+	Current bool
+}
+
+// recovered writes the response for a panic Recover caught, a page with the
+// details for browsers in development and a plain 500 otherwise. Recover has
+// already logged it.
+func (app *Application) recovered(w http.ResponseWriter, r *http.Request, p *middleware.Panic) {
+	if !app.dev || middleware.WantsJSON(r) || middleware.IsHTMX(r) {
+		app.writeError(w, r, http.StatusInternalServerError, fmt.Errorf("panic: %v", p.Value))
+		return
+	}
+
+	page := debugPage{
+		Value:      fmt.Sprint(p.Value),
+		Type:       fmt.Sprintf("%T", p.Value),
+		RequestID:  middleware.GetRequestID(r),
+		Method:     r.Method,
+		URL:        r.URL.String(),
+		Proto:      r.Proto,
+		RemoteAddr: r.RemoteAddr,
+		Headers:    debugHeaders(r.Header),
+		Stack:      string(p.Stack),
+	}
+
+	withSource := 0
+	for i, f := range p.Frames {
+		if i == debugFrames {
+			break
+		}
+		frame := debugFrame{Frame: f}
+		if withSource < debugSourceFrames && !strings.HasPrefix(f.File, runtime.GOROOT()) {
+			frame.Source = readSource(f.File, f.Line, debugSourceLines)
+			withSource++
+		}
+		page.Frames = append(page.Frames, frame)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	if err := debugTemplate.Execute(w, page); err != nil {
+		fmt.Fprintf(w, "panic: %v\n\n%s", p.Value, p.Stack)
+	}
+}
+
+// debugHeaders lists the request headers, hiding credentials
+func debugHeaders(h http.Header) []debugHeader {
+	var headers []debugHeader
+	for name, values := range h {
+		value := strings.Join(values, ", ")
+		switch name {
+		case "Authorization", "Cookie", "Proxy-Authorization":
+			value = "[hidden]"
+		}
+		headers = append(headers, debugHeader{Name: name, Value: value})
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers
+}
+
+// readSource returns the lines of file around line, nil when it can't be read
+func readSource(file string, line, around int) []sourceLine {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var lines []sourceLine
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan() && n <= line+around; n++ {
+		if n >= line-around {
+			lines = append(lines, sourceLine{Number: n, Text: scanner.Text(), Current: n == line})
+		}
+	}
+	return lines
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/MickDuprez/gobase/core/interfaces"
+	"github.com/MickDuprez/gobase/core/middleware"
+	"github.com/MickDuprez/gobase/core/template"
+)
+
+type contextKey string
+
+const featureKey contextKey = "feature"
+
+// ErrorTarget is the id of the element htmx error fragments are swapped
+// into. htmx doesn't swap error responses by itself, the base layout has the
+// element and an htmx:beforeSwap listener that swaps responses retargeted
+// to it.
+const ErrorTarget = "htmx-error"
+
+// errorMessages are shown when there's no error to explain the status
+var errorMessages = map[int]string{
+	http.StatusBadRequest:          "The request couldn't be understood.",
+	http.StatusForbidden:           "You don't have permission to see this page.",
+	http.StatusNotFound:            "The page you're looking for doesn't exist.",
+	http.StatusMethodNotAllowed:    "That isn't something you can do with this page.",
+	http.StatusInternalServerError: "Something went wrong on our side, please try again later.",
+}
+
+// Error writes an error response in the form the client asked for: JSON for
+// API clients, a fragment for htmx, swapped into the layout's ErrorTarget,
+// and otherwise an error page, the feature's own when it has one. For client
+// errors err's message is shown, server errors are logged and err is only
+// shown in development.
+//
+//	h.app.Error(w, r, http.StatusNotFound, nil)
+//	h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to load user: %w", err))
+func (app *Application) Error(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if status >= 500 {
+		log.Printf("Error %d for %s %s request %s: %v", status, r.Method, r.URL.Path, middleware.GetRequestID(r), err)
+	}
+	app.writeError(w, r, status, err)
+}
+
+func (app *Application) writeError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	page := template.ErrorPage{
+		Status:    status,
+		Message:   errorMessages[status],
+		RequestID: middleware.GetRequestID(r),
+	}
+	if err != nil && (status < 500 || app.dev) {
+		page.Message = err.Error()
+	}
+	if page.Message == "" && status >= 500 {
+		page.Message = errorMessages[http.StatusInternalServerError]
+	}
+
+	feature := app.requestFeature(r)
+
+	switch {
+	case middleware.WantsJSON(r):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":      page.Message,
+			"request_id": page.RequestID,
+		})
+
+	case middleware.IsHTMX(r):
+		w.Header().Set("HX-Retarget", "#"+ErrorTarget)
+		w.Header().Set("HX-Reswap", "innerHTML")
+		err = app.templates.RenderErrorFragment(w, r, feature, page)
+
+	default:
+		err = app.templates.RenderError(w, r, feature, page)
+	}
+	if err != nil {
+		log.Printf("Error rendering error page: %v", err)
+		http.Error(w, http.StatusText(status), status)
+	}
+}
+
+// withFeature tags requests with the feature whose route handles them, so
+// errors can use the feature's error pages
+func withFeature(name string) interfaces.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			next(w, r.WithContext(context.WithValue(r.Context(), featureKey, name)))
+		}
+	}
+}
+
+// requestFeature is the feature handling the request, or for one that
+// matched no route the feature mounted where it was going
+func (app *Application) requestFeature(r *http.Request) string {
+	if name, ok := r.Context().Value(featureKey).(string); ok {
+		return name
+	}
+
+	name, longest := "", 0
+	for _, f := range app.features {
+		if f.Mount == "" || len(f.Mount) <= longest {
+			continue
+		}
+		if r.URL.Path == f.Mount || strings.HasPrefix(r.URL.Path, f.Mount+"/") {
+			name, longest = f.Name, len(f.Mount)
+		}
+	}
+	return name
+}
+
+// serveMux routes the request, answering requests that match no route with
+// the app's error pages rather than ServeMux's plain text ones
+func (app *Application) serveMux(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := app.mux.Handler(r); pattern != "" {
+		app.mux.ServeHTTP(w, r)
+		return
+	}
+
+	// ServeMux's own handler knows whether it's a 404 or a 405
+	rec := &muxError{header: make(http.Header)}
+	app.mux.ServeHTTP(rec, r)
+	if allow := rec.header.Get("Allow"); allow != "" {
+		w.Header().Set("Allow", allow)
+	}
+	app.writeError(w, r, rec.status, nil)
+}
+
+// muxError records the status ServeMux gives a request it has no route for
+type muxError struct {
+	header http.Header
+	status int
+}
+
+func (m *muxError) Header() http.Header {
+	return m.header
+}
+
+func (m *muxError) WriteHeader(status int) {
+	if m.status == 0 {
+		m.status = status
+	}
+}
+
+func (m *muxError) Write(b []byte) (int, error) {
+	if m.status == 0 {
+		m.status = http.StatusOK
+	}
+	return len(b), nil
+}
@@ -0,0 +1,253 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/MickDuprez/gobase/core/interfaces"
+)
+
+// RegisterFeature adds a feature to the app. Features are set up by Init once
+// they've all been registered, so they can be put in dependency order.
+// Features the config doesn't enable are skipped.
+func (app *Application) RegisterFeature(f interfaces.Feature) error {
+	if app.initialized {
+		return fmt.Errorf("feature %s registered after the app was initialized", f.Name)
+	}
+	if _, ok := app.features[f.Name]; ok {
+		return fmt.Errorf("feature %s is already registered", f.Name)
+	}
+	if !app.featureConfig.IsEnabled(f.Name) {
+		log.Printf("Feature %s is disabled", f.Name)
+		app.disabled = append(app.disabled, &f)
+		return nil
+	}
+
+	app.features[f.Name] = &f
+	app.registered = append(app.registered, &f)
+	return nil
+}
+
+// Feature returns a registered feature, so a feature can use another's API
+//
+//	if f, ok := app.Feature("search"); ok {
+//		index := f.API.(*search.Index)
+//	}
+func (app *Application) Feature(name string) (interfaces.Feature, bool) {
+	f, ok := app.features[name]
+	if !ok {
+		return interfaces.Feature{}, false
+	}
+	return *f, true
+}
+
+// Init sets up the registered features, each after the features it depends
+// on, and checks the templates only link to routes that exist. Run calls it
+// if it hasn't been already.
+func (app *Application) Init() error {
+	if app.initialized {
+		return nil
+	}
+
+	app.checkFeatureConfig()
+
+	sorted, err := sortFeatures(app.registered, app.disabled)
+	if err != nil {
+		return err
+	}
+	for _, f := range sorted {
+		if err := app.setupFeature(f); err != nil {
+			return err
+		}
+	}
+	app.registered = sorted
+	app.initialized = true
+
+	return app.ValidateRoutes()
+}
+
+// checkFeatureConfig warns about features in the config that were never
+// registered, most likely a typo
+func (app *Application) checkFeatureConfig() {
+	known := make(map[string]bool)
+	for _, f := range append(app.registered, app.disabled...) {
+		known[f.Name] = true
+	}
+
+	for _, name := range app.featureConfig.Enabled {
+		if !known[name] {
+			log.Printf("WARNING: feature %s is enabled but isn't registered", name)
+		}
+	}
+	for name := range app.featureConfig.Settings {
+		if !known[name] {
+			log.Printf("WARNING: there are settings for feature %s but it isn't registered", name)
+		}
+	}
+}
+
+// loadSettings decodes the feature's settings block from the config into its
+// Settings, unknown keys are an error so typos don't go unnoticed
+func (app *Application) loadSettings(f *interfaces.Feature) error {
+	block, ok := app.featureConfig.Settings[f.Name]
+	if !ok {
+		return nil
+	}
+	if f.Settings == nil {
+		return fmt.Errorf("feature %s doesn't take settings", f.Name)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(block))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(f.Settings); err != nil {
+		return fmt.Errorf("invalid settings for feature %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+func (app *Application) setupFeature(f *interfaces.Feature) error {
+	if err := app.loadSettings(f); err != nil {
+		return err
+	}
+
+	// Register feature's templates
+	if err := app.templates.RegisterFeature(*f); err != nil {
+		return err
+	}
+
+	// Register the feature's part of the user profile
+	if err := app.auth.RegisterProfileFields(f.Name, f.ProfileFields...); err != nil {
+		return err
+	}
+
+	// Its job handlers, the workers start with the app
+	if f.Jobs != nil {
+		for jobType, h := range f.Jobs(app) {
+			if err := app.jobs.Register(jobType, h); err != nil {
+				return fmt.Errorf("feature %s: %w", f.Name, err)
+			}
+		}
+	}
+
+	// Set up feature's routes, under its mount and with its middleware
+	f.Routes(&featureApp{
+		Application: app,
+		mount:       f.Mount,
+		middleware:  append([]interfaces.Middleware{withFeature(f.Name)}, f.Middleware...),
+	})
+
+	if f.OnInit != nil {
+		if err := f.OnInit(app); err != nil {
+			return fmt.Errorf("failed to initialize feature %s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// sortFeatures orders features so each comes after the ones it depends on,
+// otherwise keeping the order they were registered in. The disabled features
+// are only there to explain a missing dependency.
+func sortFeatures(features, disabled []*interfaces.Feature) ([]*interfaces.Feature, error) {
+	// a feature provides its own name as well as its Provides
+	providers := make(map[string]int)
+	for i, f := range features {
+		for _, name := range append([]string{f.Name}, f.Provides...) {
+			if j, ok := providers[name]; ok {
+				return nil, fmt.Errorf("%q is provided by both feature %s and feature %s", name, features[j].Name, f.Name)
+			}
+			providers[name] = i
+		}
+	}
+
+	deps := make([][]int, len(features))
+	for i, f := range features {
+		for _, name := range f.DependsOn {
+			j, ok := providers[name]
+			if !ok {
+				if d := provider(disabled, name); d != nil {
+					return nil, fmt.Errorf("feature %s depends on %q, which is provided by feature %s but it's disabled", f.Name, name, d.Name)
+				}
+				return nil, fmt.Errorf("feature %s depends on %q, which no registered feature provides", f.Name, name)
+			}
+			deps[i] = append(deps[i], j)
+		}
+	}
+
+	sorted := make([]*interfaces.Feature, 0, len(features))
+	done := make([]bool, len(features))
+	for len(sorted) < len(features) {
+		next := -1
+		for i := range features {
+			if !done[i] && allDone(deps[i], done) {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			return nil, fmt.Errorf("features depend on each other: %s", dependencyCycle(features, deps, done))
+		}
+
+		done[next] = true
+		sorted = append(sorted, features[next])
+	}
+	return sorted, nil
+}
+
+func provider(features []*interfaces.Feature, name string) *interfaces.Feature {
+	for _, f := range features {
+		if f.Name == name {
+			return f
+		}
+		for _, p := range f.Provides {
+			if p == name {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+func allDone(deps []int, done []bool) bool {
+	for _, d := range deps {
+		if !done[d] {
+			return false
+		}
+	}
+	return true
+}
+
+// dependencyCycle describes a cycle among the features that couldn't be
+// sorted, every one of them depends on another that couldn't be
+func dependencyCycle(features []*interfaces.Feature, deps [][]int, done []bool) string {
+	start := 0
+	for done[start] {
+		start++
+	}
+
+	// follow dependencies until one comes round again
+	seen := make(map[int]int)
+	var path []int
+	for i := start; ; {
+		if at, ok := seen[i]; ok {
+			path = append(path[at:], i)
+			break
+		}
+		seen[i] = len(path)
+		path = append(path, i)
+		for _, d := range deps[i] {
+			if !done[d] {
+				i = d
+				break
+			}
+		}
+	}
+
+	names := make([]string, len(path))
+	for k, i := range path {
+		names[k] = features[i].Name
+	}
+	return strings.Join(names, " -> ")
+}
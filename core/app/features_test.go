@@ -0,0 +1,76 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/MickDuprez/gobase/core/interfaces"
+)
+
+func TestSortFeatures(t *testing.T) {
+	// f builds a feature from "name: dep dep", with what it provides after a "+"
+	f := func(spec string) *interfaces.Feature {
+		name, rest, _ := strings.Cut(spec, ":")
+		deps, provides, _ := strings.Cut(rest, "+")
+		return &interfaces.Feature{
+			Name:      strings.TrimSpace(name),
+			DependsOn: strings.Fields(deps),
+			Provides:  strings.Fields(provides),
+		}
+	}
+
+	tests := []struct {
+		name     string
+		features []string
+		disabled []string
+		want     string // the names in order, or part of the error
+		wantErr  bool
+	}{
+		{"registration order", []string{"a", "b", "c"}, nil, "a b c", false},
+		{"after a dependency", []string{"a: c", "b", "c"}, nil, "b c a", false},
+		{"chain", []string{"a: b", "b: c", "c"}, nil, "c b a", false},
+		// independent features keep their order around the ones that move
+		{"stable", []string{"a", "b: d", "c", "d", "e"}, nil, "a c d b e", false},
+		{"shared dependency", []string{"a: c", "b: c", "c"}, nil, "c a b", false},
+		{"on what's provided", []string{"profile: login", "users: + login"}, nil, "users profile", false},
+
+		{"missing", []string{"a: b"}, nil, `feature a depends on "b", which no registered feature provides`, true},
+		{"disabled", []string{"profile: login"}, []string{"users: + login"}, `provided by feature users but it's disabled`, true},
+		{"provided twice", []string{"users: + login", "sso: + login"}, nil, `"login" is provided by both feature users and feature sso`, true},
+		{"clashes with a name", []string{"users", "sso: + users"}, nil, `"users" is provided by both`, true},
+		{"cycle", []string{"a: b", "b: c", "c: a"}, nil, "features depend on each other: a -> b -> c -> a", true},
+		{"self", []string{"a", "b: b"}, nil, "features depend on each other: b -> b", true},
+		// only the features in the cycle are named, not ones waiting on it
+		{"waiting on a cycle", []string{"a: b", "b: c", "c: b"}, nil, "features depend on each other: b -> c -> b", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var features, disabled []*interfaces.Feature
+			for _, spec := range tt.features {
+				features = append(features, f(spec))
+			}
+			for _, spec := range tt.disabled {
+				disabled = append(disabled, f(spec))
+			}
+
+			sorted, err := sortFeatures(features, disabled)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("sortFeatures: %v, want an error containing %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sortFeatures: %v", err)
+			}
+			names := make([]string, len(sorted))
+			for i, f := range sorted {
+				names[i] = f.Name
+			}
+			if got := strings.Join(names, " "); got != tt.want {
+				t.Errorf("sorted %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package app
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/MickDuprez/gobase/core/flags"
+	"github.com/MickDuprez/gobase/core/template"
+)
+
+func (app *Application) Flags() *flags.Store {
+	return app.flags
+}
+
+// FlagEnabled reports whether the flag is on for the user making the request,
+// visitors who aren't logged in only get flags that are on for everyone
+func (app *Application) FlagEnabled(r *http.Request, name string) bool {
+	var subject flags.Subject
+	if user := app.auth.UserFor(r); user != nil {
+		subject.UserID = user.ID
+
+		roles, err := app.auth.GetUserRoles(user.ID)
+		if err != nil {
+			log.Printf("Error loading roles for flag %s: %v", name, err)
+		}
+		subject.Roles = roles
+	}
+	return app.flags.Enabled(name, subject)
+}
+
+// flagHelper is the flag template helper, it's given the page so it can
+// check the flag for the request: {{if flag $ "new-profile"}}
+func (app *Application) flagHelper(page interface{}, name string) bool {
+	r := template.Request(page)
+	if r == nil {
+		return false
+	}
+	return app.FlagEnabled(r, name)
+}
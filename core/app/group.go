@@ -0,0 +1,81 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/MickDuprez/gobase/core/interfaces"
+)
+
+// group is a Router that prefixes its patterns and wraps its handlers
+type group struct {
+	app        *Application
+	prefix     string
+	middleware []interfaces.Middleware
+}
+
+// Group starts a group of routes under prefix, for example
+//
+//	profile := app.Group("/profile", app.RequireAuth)
+//	profile.Handle("GET /", h.profile)       // GET /profile
+//	profile.Handle("GET /tokens", h.tokens)  // GET /profile/tokens
+func (app *Application) Group(prefix string, mw ...interfaces.Middleware) interfaces.Router {
+	return &group{app: app, prefix: strings.TrimSuffix(prefix, "/"), middleware: mw}
+}
+
+func (g *group) Handle(pattern string, handler http.HandlerFunc) interfaces.Route {
+	// the first middleware is the outermost
+	for i := len(g.middleware) - 1; i >= 0; i-- {
+		handler = g.middleware[i](handler)
+	}
+	return g.app.Handle(joinPattern(g.prefix, pattern), handler)
+}
+
+// Group nests a group, its middleware runs after this group's
+func (g *group) Group(prefix string, mw ...interfaces.Middleware) interfaces.Router {
+	return &group{
+		app:        g.app,
+		prefix:     g.prefix + strings.TrimSuffix(prefix, "/"),
+		middleware: append(append([]interfaces.Middleware{}, g.middleware...), mw...),
+	}
+}
+
+// joinPattern puts prefix in front of the path in a "METHOD /path" pattern.
+// A path of "/" means the prefix itself, so "GET /" in a "/profile" group is
+// "GET /profile" rather than everything under it.
+func joinPattern(prefix, pattern string) string {
+	method, path, found := strings.Cut(pattern, " ")
+	path = strings.TrimSpace(path)
+	if !found {
+		method, path = "", pattern
+	}
+
+	path = interfaces.JoinPath(prefix, path)
+	if method == "" {
+		return path
+	}
+	return method + " " + path
+}
+
+// featureApp is the App a feature sees, its routes are registered under the
+// feature's mount and wrapped in its middleware
+type featureApp struct {
+	*Application
+	mount      string
+	middleware []interfaces.Middleware
+}
+
+func (f *featureApp) Handle(pattern string, handler http.HandlerFunc) interfaces.Route {
+	for i := len(f.middleware) - 1; i >= 0; i-- {
+		handler = f.middleware[i](handler)
+	}
+	return f.Application.Handle(joinPattern(f.mount, pattern), handler)
+}
+
+// Group runs the feature's middleware before the group's
+func (f *featureApp) Group(prefix string, mw ...interfaces.Middleware) interfaces.Router {
+	return f.Application.Group(
+		interfaces.JoinPath(f.mount, prefix),
+		append(append([]interfaces.Middleware{}, f.middleware...), mw...)...,
+	)
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/MickDuprez/gobase/core/interfaces"
+)
+
+// route is returned by Handle so the route can be named
+type route struct {
+	app     *Application
+	pattern string
+}
+
+// Name registers the route's path under name. Like ServeMux with clashing
+// patterns, naming two routes the same panics as it's a bug in the app.
+func (r *route) Name(name string) interfaces.Route {
+	if existing, ok := r.app.routes[name]; ok {
+		panic(fmt.Sprintf("route name %q is already used for %s", name, existing))
+	}
+	r.app.routes[name] = routePath(r.pattern)
+	return r
+}
+
+// routePath drops the method and host from a ServeMux pattern
+func routePath(pattern string) string {
+	if _, path, found := strings.Cut(pattern, " "); found {
+		pattern = strings.TrimSpace(path)
+	}
+	if i := strings.Index(pattern, "/"); i > 0 {
+		pattern = pattern[i:]
+	}
+	return pattern
+}
+
+var wildcardRe = regexp.MustCompile(`\{([^}]*)\}`)
+
+// wildcards returns the names of the wildcards in path that URL fills in
+func wildcards(path string) []string {
+	var names []string
+	for _, m := range wildcardRe.FindAllStringSubmatch(path, -1) {
+		if m[1] != "$" {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// URL builds the path for a named route, params fill its wildcards in order
+// and are escaped. A trailing {name...} wildcard keeps its slashes.
+//
+//	app.Handle("POST /profile/tokens/{id}/revoke", h.revokeToken).Name("users.tokens.revoke")
+//	app.URL("users.tokens.revoke", 42) // "/profile/tokens/42/revoke"
+func (app *Application) URL(name string, params ...interface{}) (string, error) {
+	path, ok := app.routes[name]
+	if !ok {
+		return "", fmt.Errorf("unknown route %q", name)
+	}
+
+	if want := len(wildcards(path)); want != len(params) {
+		return "", fmt.Errorf("route %q needs %d params, got %d", name, want, len(params))
+	}
+
+	i := 0
+	return wildcardRe.ReplaceAllStringFunc(path, func(w string) string {
+		if w == "{$}" {
+			return ""
+		}
+		value := fmt.Sprint(params[i])
+		i++
+		if strings.HasSuffix(w, "...}") {
+			segments := strings.Split(value, "/")
+			for j, s := range segments {
+				segments[j] = url.PathEscape(s)
+			}
+			return strings.Join(segments, "/")
+		}
+		return url.PathEscape(value)
+	}), nil
+}
+
+// ValidateRoutes checks every url helper call in the templates names a route
+// that exists with the right number of params. Call it once all features
+// are registered so a typo fails at startup rather than when the page is
+// first shown.
+func (app *Application) ValidateRoutes() error {
+	var problems []string
+	for _, ref := range app.templates.RouteReferences() {
+		path, ok := app.routes[ref.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: unknown route %q", ref.Template, ref.Name))
+			continue
+		}
+		if want := len(wildcards(path)); ref.Params >= 0 && want != ref.Params {
+			problems = append(problems, fmt.Sprintf("%s: route %q needs %d params, got %d", ref.Template, ref.Name, want, ref.Params))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid routes in templates:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/MickDuprez/gobase/core/interfaces"
+	"github.com/MickDuprez/gobase/core/template"
+)
+
+// newTestApp is an app with just enough to register and name routes
+func newTestApp(t *testing.T) *Application {
+	t.Helper()
+	tm, err := template.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &Application{
+		templates: tm,
+		mux:       http.NewServeMux(),
+		features:  make(map[string]*interfaces.Feature),
+		routes:    make(map[string]string),
+	}
+	tm.SetRouteResolver(app.URL)
+	return app
+}
+
+// say is a handler that writes what it's given
+func say(s string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(s))
+	}
+}
+
+// get returns the status and body of a GET to the app's routes
+func get(app *Application, target string) (int, string) {
+	w := httptest.NewRecorder()
+	app.mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w.Code, w.Body.String()
+}
+
+func TestJoinPattern(t *testing.T) {
+	tests := []struct {
+		prefix, pattern, want string
+	}{
+		{"/profile", "GET /tokens", "GET /profile/tokens"},
+		// the group's own path, not everything under it
+		{"/profile", "GET /", "GET /profile"},
+		{"/admin", "POST /users/{id}/disable", "POST /admin/users/{id}/disable"},
+		{"/admin", "GET  /users", "GET /admin/users"},
+		{"/static", "/css/", "/static/css/"},
+		{"/about", "/", "/about"},
+		{"", "GET /", "GET /"},
+		{"", "GET /tokens", "GET /tokens"},
+	}
+	for _, tt := range tests {
+		if got := joinPattern(tt.prefix, tt.pattern); got != tt.want {
+			t.Errorf("joinPattern(%q, %q) = %q, want %q", tt.prefix, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestGroup(t *testing.T) {
+	app := newTestApp(t)
+	var ran []string
+	mw := func(name string) interfaces.Middleware {
+		return func(next http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				ran = append(ran, name)
+				next(w, r)
+			}
+		}
+	}
+
+	profile := app.Group("/profile/", mw("outer"))
+	profile.Handle("GET /", say("profile"))
+	profile.Handle("GET /tokens", say("tokens"))
+	profile.Group("/admin", mw("inner")).Handle("GET /", say("admin"))
+
+	tests := []struct {
+		target string
+		status int
+		body   string
+		ran    string
+	}{
+		{"/profile", http.StatusOK, "profile", "outer"},
+		{"/profile/tokens", http.StatusOK, "tokens", "outer"},
+		{"/profile/admin", http.StatusOK, "admin", "outer inner"},
+		// "GET /" in the group isn't a catch all
+		{"/profile/other", http.StatusNotFound, "", ""},
+		{"/", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		ran = nil
+		status, body := get(app, tt.target)
+		if status != tt.status || (tt.body != "" && body != tt.body) {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.target, status, body, tt.status, tt.body)
+		}
+		if got := strings.Join(ran, " "); got != tt.ran {
+			t.Errorf("GET %s ran middleware %q, want %q", tt.target, got, tt.ran)
+		}
+	}
+}
+
+func TestFeatureMount(t *testing.T) {
+	app := newTestApp(t)
+	var ran []string
+	feature := &featureApp{
+		Application: app,
+		mount:       "/company",
+		middleware: []interfaces.Middleware{func(next http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				ran = append(ran, "feature")
+				next(w, r)
+			}
+		}},
+	}
+	feature.Handle("GET /", say("about")).Name("about.index")
+	feature.Group("/team").Handle("GET /{name}", say("member")).Name("about.member")
+
+	for target, want := range map[string]string{"/company": "about", "/company/team/ann": "member"} {
+		ran = nil
+		if status, body := get(app, target); status != http.StatusOK || body != want {
+			t.Errorf("GET %s = %d %q, want %q", target, status, body, want)
+		}
+		if len(ran) != 1 {
+			t.Errorf("GET %s ran the feature's middleware %d times, want once", target, len(ran))
+		}
+	}
+	if got, err := app.URL("about.member", "ann"); err != nil || got != "/company/team/ann" {
+		t.Errorf(`URL("about.member") = %q, %v, want "/company/team/ann"`, got, err)
+	}
+}
+
+func TestURL(t *testing.T) {
+	app := newTestApp(t)
+	app.Handle("GET /", say("")).Name("home")
+	app.Handle("GET /{$}", say("")).Name("home.exact")
+	app.Handle("POST /profile/tokens/{id}/revoke", say("")).Name("tokens.revoke")
+	app.Handle("GET /teams/{team}/members/{member}", say("")).Name("members.show")
+	app.Handle("GET /files/{path...}", say("")).Name("files")
+	app.Handle("GET example.com/about", say("")).Name("about")
+
+	tests := []struct {
+		name    string
+		params  []interface{}
+		want    string
+		wantErr bool
+	}{
+		{"home", nil, "/", false},
+		{"home.exact", nil, "/", false},
+		{"tokens.revoke", []interface{}{42}, "/profile/tokens/42/revoke", false},
+		{"members.show", []interface{}{"red", 7}, "/teams/red/members/7", false},
+		// params are escaped, except the slashes of a trailing wildcard
+		{"tokens.revoke", []interface{}{"a/b c"}, "/profile/tokens/a%2Fb%20c/revoke", false},
+		{"files", []interface{}{"docs/a b.txt"}, "/files/docs/a%20b.txt", false},
+		{"about", nil, "/about", false},
+
+		{"tokens.revoke", nil, "", true},
+		{"members.show", []interface{}{"red"}, "", true},
+		{"home", []interface{}{1}, "", true},
+		{"missing", nil, "", true},
+	}
+	for _, tt := range tests {
+		got, err := app.URL(tt.name, tt.params...)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("URL(%q, %v) = %q, %v, want %q", tt.name, tt.params, got, err, tt.want)
+		}
+	}
+}
+
+func TestRouteNameUsedTwice(t *testing.T) {
+	app := newTestApp(t)
+	app.Handle("GET /login", say("")).Name("users.login")
+
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), `route name "users.login" is already used for /login`) {
+			t.Errorf("naming a second route the same: recovered %v, want a panic", r)
+		}
+	}()
+	app.Handle("POST /sign-in", say("")).Name("users.login")
+}
+
+func TestValidateRoutes(t *testing.T) {
+	// templates are parsed with the app's layout, found from the working
+	// directory
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "templates", "layouts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	layout := `{{define "base"}}{{template "content" .}}{{end}}`
+	if err := os.WriteFile(filepath.Join(dir, "templates", "layouts", "base.html"), []byte(layout), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	register := func(app *Application, page string) {
+		t.Helper()
+		err := app.templates.RegisterFeature(interfaces.Feature{
+			Name: "team",
+			FS: fstest.MapFS{
+				"templates/layout.html": {Data: []byte(`{{define "content"}}{{template "page" .}}{{end}}`)},
+				"templates/index.html":  {Data: []byte(`{{define "page"}}` + page + `{{end}}`)},
+			},
+		})
+		if err != nil {
+			t.Fatalf("RegisterFeature: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		page string
+		want string // part of the error, empty for none
+	}{
+		{"valid", `<a href="{{url "team.member" .ID}}">{{url "/"}}</a>`, ""},
+		{"unknown", `{{url "team.members" .ID}}`, `team/index.html: unknown route "team.members"`},
+		{"too few params", `{{url "team.member"}}`, `team/index.html: route "team.member" needs 1 params, got 0`},
+		{"too many params", `{{url "team.index" 1}}`, `route "team.index" needs 0 params, got 1`},
+		// the count can't be told when params are passed on from a pipeline
+		{"unknown count", `{{.ID | url "team.member"}}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			app.Handle("GET /team", say("")).Name("team.index")
+			app.Handle("GET /team/{id}", say("")).Name("team.member")
+			register(app, tt.page)
+
+			err := app.ValidateRoutes()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("ValidateRoutes: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ValidateRoutes: %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Run serves the app until ctx is done or the process gets SIGINT or
+// SIGTERM. Features' OnStart hooks run, in dependency order, once the port
+// is open, then the job workers and scheduled tasks start, all before any
+// requests are served. On the way out requests in flight finish, scheduled
+// tasks are cancelled, running jobs are put back, OnShutdown hooks run in the
+// reverse order, async event subscribers finish and the databases are closed,
+// all within the one shutdown timeout.
+func (app *Application) Run(ctx context.Context) error {
+	if err := app.Init(); err != nil {
+		app.Close()
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{
+		Addr:              app.server.Port,
+		Handler:           app,
+		ReadHeaderTimeout: app.server.ReadHeaderTimeout,
+		ReadTimeout:       app.server.ReadTimeout,
+		WriteTimeout:      app.server.WriteTimeout,
+		IdleTimeout:       app.server.IdleTimeout,
+	}
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		app.Close()
+		return fmt.Errorf("failed to listen on %s: %w", srv.Addr, err)
+	}
+
+	started, err := app.start(ctx)
+	if err == nil {
+		err = app.jobs.Start()
+	}
+	if err == nil {
+		err = app.scheduler.Start()
+	}
+	if err != nil {
+		ln.Close()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), app.server.ShutdownTimeout)
+		defer cancel()
+		return errors.Join(err, app.shutdown(shutdownCtx, started))
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+	log.Printf("Server starting on %s", srv.Addr)
+
+	select {
+	case err = <-serveErr:
+		err = fmt.Errorf("server stopped: %w", err)
+	case <-ctx.Done():
+		// a second signal kills the process rather than waiting for the drain
+		stop()
+	}
+
+	// one deadline for all of it, so shutting down takes no longer than the
+	// timeout however the time is split
+	log.Printf("Shutting down, waiting up to %v for requests and background work to finish", app.server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.server.ShutdownTimeout)
+	defer cancel()
+	if err == nil {
+		if err = srv.Shutdown(shutdownCtx); err != nil {
+			err = fmt.Errorf("failed to drain requests: %w", err)
+		}
+	}
+
+	if err = errors.Join(err, app.shutdown(shutdownCtx, started)); err == nil {
+		log.Printf("Server stopped")
+	}
+	return err
+}
+
+// start runs the OnStart hooks, returning how many features started
+func (app *Application) start(ctx context.Context) (int, error) {
+	for i, f := range app.registered {
+		if f.OnStart == nil {
+			continue
+		}
+		if err := f.OnStart(ctx, app); err != nil {
+			return i, fmt.Errorf("failed to start feature %s: %w", f.Name, err)
+		}
+	}
+	return len(app.registered), nil
+}
+
+// shutdown stops the scheduled tasks and job workers, runs the OnShutdown
+// hooks of the first started features, last first, waits for async event
+// subscribers then closes the databases. Waiting stops when ctx is done.
+func (app *Application) shutdown(ctx context.Context, started int) error {
+	var errs []error
+	// tasks may queue jobs, so they stop first
+	if err := app.scheduler.Stop(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := app.jobs.Stop(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	for i := started - 1; i >= 0; i-- {
+		f := app.registered[i]
+		if f.OnShutdown == nil {
+			continue
+		}
+		if err := f.OnShutdown(ctx, app); err != nil {
+			errs = append(errs, fmt.Errorf("failed to shut down feature %s: %w", f.Name, err))
+		}
+	}
+
+	if err := app.events.Close(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, app.Close())
+	return errors.Join(errs...)
+}
+
+// Close closes the app's databases, Run does this on the way out
+func (app *Application) Close() error {
+	var errs []error
+	if err := app.auth.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close auth database: %w", err))
+	}
+	if err := app.flags.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close flags database: %w", err))
+	}
+	if err := app.jobs.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close jobs database: %w", err))
+	}
+	if err := app.scheduler.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close schedule database: %w", err))
+	}
+	if app.db != nil {
+		if err := app.db.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close database: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MickDuprez/gobase/core/middleware"
+)
+
+// Audit event types recorded by gobase, features can add their own using
+// the same feature.action naming
+const (
+	AuditLoginSuccess   = "login.success"
+	AuditLoginFailure   = "login.failure"
+	AuditLogout         = "logout"
+	AuditUserCreate     = "user.create"
+	AuditUserUpdate     = "user.update"
+	AuditUserDisable    = "user.disable"
+	AuditUserEnable     = "user.enable"
+	AuditUserDelete     = "user.delete"
+	AuditPasswordChange = "password.change"
+	AuditPasswordReset  = "password.reset_required"
+	AuditEmailChange    = "email.change"
+	AuditSessionRevoke  = "session.revoke"
+	AuditRoleAssign     = "role.assign"
+	AuditRoleRemove     = "role.remove"
+	AuditTokenCreate    = "token.create"
+	AuditTokenRevoke    = "token.revoke"
+
+	AuditImpersonationStart = "impersonation.start"
+	AuditImpersonationEnd   = "impersonation.end"
+	AuditInvitationCreate   = "invitation.create"
+	AuditInvitationRevoke   = "invitation.revoke"
+	AuditFlagUpdate         = "flag.update"
+	AuditTaskRun            = "task.run"
+)
+
+// AuditEvent is one entry in the audit log. UserID is who the event is
+// about and ActorID who caused it, they differ when an admin acts on
+// someone else's account, or when an admin is impersonating the user.
+type AuditEvent struct {
+	ID        int64
+	Type      string
+	UserID    int64 // 0 when there's no user, e.g. a login for an unknown email
+	ActorID   int64
+	Email     string
+	IP        string
+	UserAgent string
+	RequestID string
+	Details   string
+	CreatedAt time.Time
+}
+
+// Audit appends an event to the audit log. The IP, user agent, request ID
+// and actor are taken from r when not set. Failures are logged, so callers
+// that can't do anything about them can ignore the error.
+func (a *AuthDB) Audit(r *http.Request, event AuditEvent) error {
+	if r != nil {
+		if event.IP == "" {
+			event.IP = a.clientIP(r)
+		}
+		if event.UserAgent == "" {
+			event.UserAgent = r.UserAgent()
+		}
+		if event.RequestID == "" {
+			event.RequestID = middleware.GetRequestID(r)
+		}
+		if event.ActorID == 0 {
+			if session := GetCurrentSession(r); session != nil && session.Impersonated() {
+				event.ActorID = session.ImpersonatorID
+			} else if user := GetUser(r); user != nil {
+				event.ActorID = user.ID
+			}
+		}
+	}
+	if event.ActorID == 0 {
+		event.ActorID = event.UserID
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	_, err := a.db.Exec(
+		`INSERT INTO audit_events (type, user_id, actor_id, email, ip, user_agent, request_id, details, created_at)
+         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.Type, nullID(event.UserID), nullID(event.ActorID), event.Email, event.IP,
+		event.UserAgent, event.RequestID, event.Details, event.CreatedAt,
+	)
+	if err != nil {
+		log.Printf("Failed to record audit event %s: %v", event.Type, err)
+	}
+	return err
+}
+
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+// clientIP is the address the request came from, or the first address in
+// X-Forwarded-For when we're configured to trust the proxy
+func (a *AuthDB) clientIP(r *http.Request) string {
+	if a.cfg.TrustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AuditFilter narrows down QueryAudit, zero fields match everything
+type AuditFilter struct {
+	Type   string // an exact type, or a prefix such as "login" for login.*
+	UserID int64  // events about or caused by the user
+	Email  string
+	IP     string
+	Since  time.Time
+	Until  time.Time
+	Offset int
+	Limit  int
+}
+
+func (f AuditFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	if f.Type != "" {
+		conds = append(conds, `(type = ? OR type LIKE ? ESCAPE '\')`)
+		args = append(args, f.Type, likeEscaper.Replace(f.Type)+".%")
+	}
+	if f.UserID != 0 {
+		conds = append(conds, `(user_id = ? OR actor_id = ?)`)
+		args = append(args, f.UserID, f.UserID)
+	}
+	if f.Email != "" {
+		conds = append(conds, `email = ? COLLATE NOCASE`)
+		args = append(args, f.Email)
+	}
+	if f.IP != "" {
+		conds = append(conds, `ip = ?`)
+		args = append(args, f.IP)
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, `created_at >= ?`)
+		args = append(args, f.Since)
+	}
+	if !f.Until.IsZero() {
+		conds = append(conds, `created_at < ?`)
+		args = append(args, f.Until)
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+const auditColumns = `id, type, user_id, actor_id, email, ip, user_agent, request_id, details, created_at`
+
+func scanAuditEvent(row interface{ Scan(...any) error }) (*AuditEvent, error) {
+	var e AuditEvent
+	var userID, actorID sql.NullInt64
+	err := row.Scan(&e.ID, &e.Type, &userID, &actorID, &e.Email, &e.IP, &e.UserAgent,
+		&e.RequestID, &e.Details, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	e.UserID = userID.Int64
+	e.ActorID = actorID.Int64
+	return &e, nil
+}
+
+// QueryAudit returns a page of matching events, newest first, along with
+// the total number of matches
+func (a *AuthDB) QueryAudit(f AuditFilter) ([]*AuditEvent, int, error) {
+	where, args := f.where()
+
+	var total int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM audit_events`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	if f.Limit <= 0 {
+		f.Limit = 50
+	}
+	rows, err := a.db.Query(
+		fmt.Sprintf(`SELECT %s FROM audit_events%s ORDER BY id DESC LIMIT ? OFFSET ?`, auditColumns, where),
+		append(args, f.Limit, f.Offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var events []*AuditEvent
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, e)
+	}
+	return events, total, rows.Err()
+}
+
+// ExportAuditCSV writes every event matching the filter as CSV, oldest
+// first. Offset and Limit are ignored.
+func (a *AuthDB) ExportAuditCSV(w io.Writer, f AuditFilter) error {
+	where, args := f.where()
+	rows, err := a.db.Query(
+		fmt.Sprintf(`SELECT %s FROM audit_events%s ORDER BY id`, auditColumns, where),
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "time", "type", "user_id", "actor_id", "email", "ip", "user_agent", "request_id", "details"})
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+		cw.Write([]string{
+			strconv.FormatInt(e.ID, 10),
+			e.CreatedAt.UTC().Format(time.RFC3339),
+			csvSafe(e.Type),
+			formatID(e.UserID),
+			formatID(e.ActorID),
+			csvSafe(e.Email),
+			csvSafe(e.IP),
+			csvSafe(e.UserAgent),
+			csvSafe(e.RequestID),
+			csvSafe(e.Details),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatID(id int64) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// csvSafe stops spreadsheets treating values such as a user agent of
+// "=HYPERLINK(...)" as formulas
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
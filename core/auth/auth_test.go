@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPassword passes the test config's password policy
+const testPassword = "correct horse battery staple"
+
+// testConfig has the cheapest bcrypt cost so tests stay quick
+func testConfig() *Config {
+	return &Config{
+		Hasher:           NewBcryptHasher(bcrypt.MinCost),
+		PasswordPolicy:   &PasswordPolicy{MinLength: 8},
+		MagicLinkTTL:     15 * time.Minute,
+		MagicLinkLimit:   3,
+		MagicLinkWindow:  15 * time.Minute,
+		RegistrationMode: RegistrationOpen,
+		InvitationTTL:    24 * time.Hour,
+	}
+}
+
+// newTestAuthDB opens an AuthDB with testConfig in a temporary directory
+func newTestAuthDB(t *testing.T, configure ...func(cfg *Config)) *AuthDB {
+	t.Helper()
+
+	cfg := testConfig()
+	for _, fn := range configure {
+		fn(cfg)
+	}
+
+	a, err := openAuthDB(filepath.Join(t.TempDir(), "auth.db"), cfg)
+	if err != nil {
+		t.Fatalf("openAuthDB: %v", err)
+	}
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+func createTestUser(t *testing.T, a *AuthDB, email string) *User {
+	t.Helper()
+
+	user, err := a.CreateUser(email, testPassword, "Test User")
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %v", email, err)
+	}
+	return user
+}
+
+// sessionRequest is a request carrying the cookie of a new session for user
+func sessionRequest(t *testing.T, a *AuthDB, method, target string, user *User) (*http.Request, *Session) {
+	t.Helper()
+
+	session, err := a.CreateSession(user.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	r, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddCookie(&http.Cookie{Name: "session_id", Value: session.ID})
+	return r, session
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// BreachedPasswords checks passwords against a local copy of a breached
+// password dataset, such as the one published by Have I Been Pwned, so no
+// password or hash ever leaves the server.
+//
+// The file has one uppercase hex SHA-1 hash per line, optionally followed by
+// ":count", sorted by hash. Lookups work like the k-anonymity range API: a
+// binary search finds the block of hashes sharing the first five characters
+// and the rest of the hash is only compared within that block.
+type BreachedPasswords struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewBreachedPasswords(path string) *BreachedPasswords {
+	return &BreachedPasswords{path: path}
+}
+
+func (b *BreachedPasswords) open() error {
+	if b.file != nil {
+		return nil
+	}
+	f, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	b.file, b.size = f, info.Size()
+	return nil
+}
+
+// Contains reports whether password appears in the dataset
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.open(); err != nil {
+		return false, err
+	}
+
+	// find the first line whose prefix is >= ours
+	lo, hi := int64(0), b.size
+	for lo < hi {
+		mid := (lo + hi) / 2
+		line, _, err := b.lineAfter(mid)
+		if err != nil {
+			return false, err
+		}
+		if line == "" || linePrefix(line) >= prefix {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	// scan the range of hashes sharing the prefix
+	offset := lo
+	for offset < b.size {
+		line, next, err := b.lineAfter(offset)
+		if err != nil {
+			return false, err
+		}
+		if line == "" || linePrefix(line) != prefix {
+			break
+		}
+		if strings.EqualFold(strings.SplitN(line, ":", 2)[0][5:], suffix) {
+			return true, nil
+		}
+		offset = next
+	}
+
+	return false, nil
+}
+
+// lineAfter returns the first complete line starting at or after offset and
+// the offset following it. Offset 0 is the start of the first line.
+func (b *BreachedPasswords) lineAfter(offset int64) (string, int64, error) {
+	start := offset
+	if offset > 0 {
+		// back up one byte so a line starting exactly at offset is found
+		start = offset - 1
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(b.file, start, b.size-start))
+	if offset > 0 {
+		skipped, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return "", b.size, nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		start += int64(len(skipped))
+	}
+
+	line, err := r.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	next := start + int64(len(line))
+	return string(bytes.TrimSpace(line)), next, nil
+}
+
+func linePrefix(line string) string {
+	if len(line) < 5 {
+		return strings.ToUpper(line)
+	}
+	return strings.ToUpper(line[:5])
+}
+
+func (b *BreachedPasswords) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.file == nil {
+		return nil
+	}
+	err := b.file.Close()
+	b.file = nil
+	return err
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/MickDuprez/gobase/core/utils"
+)
+
+// Registration modes, who may create an account
+const (
+	RegistrationOpen    = "open"    // anyone
+	RegistrationInvite  = "invite"  // only with an invitation from an admin
+	RegistrationDomains = "domains" // emails in AllowedDomains, or with an invitation
+	RegistrationClosed  = "closed"  // nobody
+)
+
+type Config struct {
+	// BaseURL is where the site is served from, e.g. https://example.com.
+	// Links sent by email are built on it, never on the request's Host
+	// header, which the client picks.
+	BaseURL string
+
+	// Hasher hashes new passwords, existing hashes made with other settings
+	// are upgraded when their user logs in
+	Hasher PasswordHasher
+	// PasswordPolicy is enforced whenever a password is set
+	PasswordPolicy *PasswordPolicy
+
+	// Passwordless lets users log in with a link sent to their email
+	Passwordless bool
+	// MagicLinkTTL is how long a login link stays valid
+	MagicLinkTTL time.Duration
+	// MagicLinkLimit is the number of login links an email can request
+	// within MagicLinkWindow
+	MagicLinkLimit  int
+	MagicLinkWindow time.Duration
+
+	// AdminEmails are given the admin role at startup, once their users have
+	// verified them, the way to create the first admin
+	AdminEmails []string
+
+	// RegistrationMode is one of the Registration constants
+	RegistrationMode string
+	// AllowedDomains are the email domains that can register in domains mode
+	AllowedDomains []string
+	// InvitationTTL is how long an invitation can be used for
+	InvitationTTL time.Duration
+
+	// TrustProxy takes the client IP for the audit log from X-Forwarded-For,
+	// only turn it on behind a proxy that sets the header
+	TrustProxy bool
+}
+
+func NewConfig() *Config {
+	policy := &PasswordPolicy{
+		MinLength:        utils.GetEnvInt("AUTH_PASSWORD_MIN_LENGTH", 8),
+		MaxLength:        utils.GetEnvInt("AUTH_PASSWORD_MAX_LENGTH", DefaultPasswordMaxLength),
+		MinStrength:      utils.GetEnvInt("AUTH_PASSWORD_MIN_STRENGTH", 2),
+		DisallowPersonal: utils.GetEnvBool("AUTH_PASSWORD_DISALLOW_PERSONAL", true),
+	}
+	if path := utils.GetEnvStr("AUTH_BREACHED_PASSWORDS_FILE", ""); path != "" {
+		policy.Breached = NewBreachedPasswords(path)
+	}
+
+	return &Config{
+		BaseURL: utils.GetEnvStr("APP_BASE_URL", "http://localhost:3000"),
+		Hasher: newPasswordHasher(
+			utils.GetEnvStr("AUTH_PASSWORD_HASHER", "bcrypt"),
+			utils.GetEnvInt("AUTH_BCRYPT_COST", 10),
+			utils.GetEnvInt("AUTH_ARGON2_MEMORY_KB", defaultArgon2Memory),
+			utils.GetEnvInt("AUTH_ARGON2_ITERATIONS", defaultArgon2Iterations),
+			utils.GetEnvInt("AUTH_ARGON2_PARALLELISM", defaultArgon2Parallelism),
+		),
+		PasswordPolicy:   policy,
+		Passwordless:     utils.GetEnvBool("AUTH_PASSWORDLESS", false),
+		MagicLinkTTL:     time.Duration(utils.GetEnvInt("AUTH_MAGIC_LINK_TTL_MINUTES", 15)) * time.Minute,
+		MagicLinkLimit:   utils.GetEnvInt("AUTH_MAGIC_LINK_LIMIT", 3),
+		MagicLinkWindow:  time.Duration(utils.GetEnvInt("AUTH_MAGIC_LINK_WINDOW_MINUTES", 15)) * time.Minute,
+		AdminEmails:      splitList(utils.GetEnvStr("AUTH_ADMIN_EMAILS", "")),
+		RegistrationMode: utils.GetEnvStr("AUTH_REGISTRATION_MODE", RegistrationOpen),
+		AllowedDomains:   splitList(utils.GetEnvStr("AUTH_ALLOWED_EMAIL_DOMAINS", "")),
+		InvitationTTL:    time.Duration(utils.GetEnvInt("AUTH_INVITATION_TTL_DAYS", 7)) * 24 * time.Hour,
+		TrustProxy:       utils.GetEnvBool("AUTH_TRUST_PROXY", false),
+	}
+}
+
+// splitList splits a comma or space separated setting
+func splitList(s string) []string {
+	return strings.Fields(strings.ReplaceAll(s, ",", " "))
+}
@@ -4,14 +4,25 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
+	"github.com/MickDuprez/gobase/core/events"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type AuthDB struct {
-	db *sql.DB
+	db  *sql.DB
+	cfg *Config
+	// events is where auth events are published, they're dropped while
+	// it's nil
+	events *events.Bus
+
+	profileMu     sync.RWMutex
+	profileFields map[string]ProfileField
 }
 
 func (a *AuthDB) SaveSession(session *Session) error {
@@ -28,24 +39,43 @@ func (a *AuthDB) SaveSession(session *Session) error {
 	return err
 }
 
-func NewAuthDB() (*AuthDB, error) {
+func NewAuthDB(cfg *Config) (*AuthDB, error) {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+
+	return openAuthDB(filepath.Join("data", "auth.db"), cfg)
+}
+
+// openAuthDB opens the auth database at path, creating it if needed
+func openAuthDB(path string, cfg *Config) (*AuthDB, error) {
 	// Ensure data directory exists
-	if err := os.MkdirAll("data", 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
-	dbPath := filepath.Join("data", "auth.db")
 	// Sqlite creates the db if it doesn't exist
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	auth := &AuthDB{db: db}
+	// bcrypt fails on longer passwords, the policy turns them away first
+	if _, ok := cfg.Hasher.(*BcryptHasher); ok && cfg.PasswordPolicy != nil {
+		if p := cfg.PasswordPolicy; p.MaxBytes <= 0 || p.MaxBytes > bcryptMaxBytes {
+			p.MaxBytes = bcryptMaxBytes
+		}
+	}
+
+	auth := &AuthDB{db: db, cfg: cfg, profileFields: make(map[string]ProfileField)}
 	if err := auth.runMigrations(); err != nil {
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
 
+	if err := auth.bootstrapAdmins(); err != nil {
+		return nil, fmt.Errorf("failed to set up admins: %w", err)
+	}
+
 	return auth, nil
 }
 
@@ -66,6 +96,99 @@ func (a *AuthDB) runMigrations() error {
             data TEXT,
             FOREIGN KEY(user_id) REFERENCES users(id)
         );`,
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            name TEXT NOT NULL,
+            token_hash TEXT UNIQUE NOT NULL,
+            prefix TEXT NOT NULL,
+            scopes TEXT NOT NULL DEFAULT '',
+            expires_at DATETIME,
+            last_used_at DATETIME,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS user_identities (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            provider TEXT NOT NULL,
+            subject TEXT NOT NULL,
+            email TEXT NOT NULL DEFAULT '',
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE(provider, subject),
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS login_links (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            email TEXT NOT NULL,
+            token_hash TEXT UNIQUE NOT NULL,
+            expires_at DATETIME NOT NULL,
+            used_at DATETIME,
+            created_at DATETIME NOT NULL
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_login_links_email ON login_links(email, created_at);`,
+		`CREATE TABLE IF NOT EXISTS email_changes (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            new_email TEXT NOT NULL,
+            token_hash TEXT UNIQUE NOT NULL,
+            expires_at DATETIME NOT NULL,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS user_profiles (
+            user_id INTEGER NOT NULL,
+            key TEXT NOT NULL,
+            value TEXT NOT NULL,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY(user_id, key),
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS roles (
+            name TEXT PRIMARY KEY,
+            description TEXT NOT NULL DEFAULT '',
+            permissions TEXT NOT NULL DEFAULT '',
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+		`CREATE TABLE IF NOT EXISTS user_roles (
+            user_id INTEGER NOT NULL,
+            role TEXT NOT NULL,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY(user_id, role),
+            FOREIGN KEY(user_id) REFERENCES users(id),
+            FOREIGN KEY(role) REFERENCES roles(name)
+        );`,
+		`INSERT OR IGNORE INTO roles (name, description, permissions) VALUES ('admin', 'Full access to the admin area', 'admin');`,
+		`INSERT OR IGNORE INTO roles (name, description, permissions) VALUES ('support', 'Can log in as other users', 'impersonate');`,
+		`CREATE TABLE IF NOT EXISTS invitations (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            email TEXT NOT NULL,
+            token_hash TEXT UNIQUE NOT NULL,
+            invited_by INTEGER,
+            expires_at DATETIME NOT NULL,
+            used_at DATETIME,
+            used_by INTEGER,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+		`CREATE TABLE IF NOT EXISTS audit_events (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            type TEXT NOT NULL,
+            user_id INTEGER,
+            actor_id INTEGER,
+            email TEXT NOT NULL DEFAULT '',
+            ip TEXT NOT NULL DEFAULT '',
+            user_agent TEXT NOT NULL DEFAULT '',
+            request_id TEXT NOT NULL DEFAULT '',
+            details TEXT NOT NULL DEFAULT '',
+            created_at DATETIME NOT NULL
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_events_user ON audit_events(user_id, created_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_events_created ON audit_events(created_at);`,
+		// the audit log is append only
+		`CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+         BEGIN SELECT RAISE(ABORT, 'audit events can not be changed'); END;`,
+		`CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+         BEGIN SELECT RAISE(ABORT, 'audit events can not be deleted'); END;`,
 	}
 
 	for _, migration := range migrations {
@@ -74,7 +197,89 @@ func (a *AuthDB) runMigrations() error {
 		}
 	}
 
-	return nil
+	// Columns added to tables that existing databases already have
+	columns := []struct {
+		table, column, definition string
+	}{
+		{"users", "disabled_at", "DATETIME"},
+		{"users", "password_reset_required", "INTEGER NOT NULL DEFAULT 0"},
+		{"users", "email_verified_at", "DATETIME"},
+		{"sessions", "impersonator_id", "INTEGER"},
+		{"sessions", "original_session_id", "TEXT"},
+	}
+
+	for _, c := range columns {
+		if err := a.ensureColumn(c.table, c.column, c.definition); err != nil {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+	}
+
+	return a.lowerCaseEmails()
+}
+
+// lowerCaseEmails stores the emails of users from before they were kept in
+// lower case the way they are now. Addresses that only differ in case from
+// another user's are left alone, and logged, for an admin to sort out.
+func (a *AuthDB) lowerCaseEmails() error {
+	if _, err := a.db.Exec(`UPDATE OR IGNORE users SET email = lower(email) WHERE email != lower(email)`); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
+	rows, err := a.db.Query(`SELECT id, email FROM users WHERE email != lower(email)`)
+	if err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var email string
+		if err := rows.Scan(&id, &email); err != nil {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+		log.Printf("User %d has email %s, which only differs in case from another user's, so was left as it is", id, email)
+	}
+	return rows.Err()
+}
+
+// ensureColumn adds a column to a table unless it's already there, sqlite
+// has no ADD COLUMN IF NOT EXISTS
+func (a *AuthDB) ensureColumn(table, column, definition string) error {
+	rows, err := a.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = a.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// Config returns the auth settings, features use it to decide which login
+// options to offer
+func (a *AuthDB) Config() *Config {
+	return a.cfg
+}
+
+// AbsoluteURL turns a path on the site into a link for an email, on the
+// configured BaseURL
+func (a *AuthDB) AbsoluteURL(path string) string {
+	return strings.TrimSuffix(a.cfg.BaseURL, "/") + path
 }
 
 func (a *AuthDB) Close() error {
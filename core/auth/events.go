@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/MickDuprez/gobase/core/events"
+)
+
+// Events published by AuthDB once it has a bus, subscribe to them with
+// events.Subscribe or events.SubscribeAsync
+
+// UserCreated is published when someone registers, or an account is made
+// for them by a login provider
+type UserCreated struct {
+	User *User
+}
+
+// UserLoggedIn is published when a session is created for a user, however
+// they logged in
+type UserLoggedIn struct {
+	UserID     int64
+	SessionKey string // the session's Key
+}
+
+// SessionRevoked is published when a session is ended before it expires,
+// by logging out or being logged out by someone else
+type SessionRevoked struct {
+	UserID     int64
+	SessionKey string // the session's Key
+}
+
+// SetEvents sets the bus auth events are published on
+func (a *AuthDB) SetEvents(bus *events.Bus) {
+	a.events = bus
+}
+
+func (a *AuthDB) publish(event interface{}) {
+	// a nil bus drops the event
+	a.events.Publish(context.Background(), event)
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Identity links a user to an account at an external login provider
+type Identity struct {
+	ID        int64
+	UserID    int64
+	Provider  string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+}
+
+// GetUserByIdentity returns the user linked to the provider account, nil if
+// the account isn't linked to anyone yet
+func (a *AuthDB) GetUserByIdentity(provider, subject string) (*User, error) {
+	var userID int64
+	err := a.db.QueryRow(
+		`SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?`,
+		provider, subject,
+	).Scan(&userID)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a.GetUserByID(userID)
+}
+
+// LinkIdentity links the provider account to the user
+func (a *AuthDB) LinkIdentity(userID int64, provider, subject, email string) (*Identity, error) {
+	result, err := a.db.Exec(
+		`INSERT INTO user_identities (user_id, provider, subject, email) VALUES (?, ?, ?, ?)`,
+		userID, provider, subject, email,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		ID:        id,
+		UserID:    userID,
+		Provider:  provider,
+		Subject:   subject,
+		Email:     email,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// ListIdentities returns the provider accounts linked to the user
+func (a *AuthDB) ListIdentities(userID int64) ([]*Identity, error) {
+	rows, err := a.db.Query(
+		`SELECT id, user_id, provider, subject, email, created_at FROM user_identities WHERE user_id = ? ORDER BY provider`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*Identity
+	for rows.Next() {
+		var i Identity
+		if err := rows.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, &i)
+	}
+	return identities, rows.Err()
+}
+
+// UnlinkIdentity removes the link between the user and a provider account
+func (a *AuthDB) UnlinkIdentity(userID int64, provider string) error {
+	_, err := a.db.Exec(`DELETE FROM user_identities WHERE user_id = ? AND provider = ?`, userID, provider)
+	return err
+}
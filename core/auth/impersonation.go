@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// impersonationTTL caps how long an admin can act as another user
+const impersonationTTL = time.Hour
+
+// ImpersonationEndURL is where the layout's banner posts to end an
+// impersonation, the feature serving it sets it to wherever it's mounted
+var ImpersonationEndURL = "/admin/impersonation/end"
+
+var (
+	ErrCannotImpersonate = errors.New("user can not be impersonated")
+	ErrNotImpersonating  = errors.New("session is not impersonating anyone")
+	ErrSessionEnded      = errors.New("original session has ended")
+)
+
+// Impersonation describes an admin acting as another user, it's what the
+// layout needs to show a banner
+type Impersonation struct {
+	User         *User
+	Impersonator *User
+	ExpiresAt    time.Time
+	EndURL       string // ImpersonationEndURL
+}
+
+// StartImpersonation creates a session that logs the admin owning session in
+// as the user. The admin's own session is kept so EndImpersonation can
+// switch back to it. Users who could impersonate others themselves can't be
+// impersonated, so it can't be used to gain permissions.
+func (a *AuthDB) StartImpersonation(session *Session, userID int64) (*Session, error) {
+	if session.Impersonated() || session.UserID == userID {
+		return nil, ErrCannotImpersonate
+	}
+
+	user, err := a.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.Disabled() {
+		return nil, ErrCannotImpersonate
+	}
+
+	if ok, err := a.HasPermission(userID, PermImpersonate); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, ErrCannotImpersonate
+	}
+
+	id, err := generateSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(impersonationTTL)
+	if session.ExpiresAt.Before(expiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+
+	impersonation := &Session{
+		ID:                id,
+		UserID:            userID,
+		CreatedAt:         time.Now(),
+		ExpiresAt:         expiresAt,
+		Data:              make(map[string]interface{}),
+		ImpersonatorID:    session.UserID,
+		originalSessionID: session.ID,
+	}
+	if err := a.insertSession(impersonation); err != nil {
+		return nil, err
+	}
+	return impersonation, nil
+}
+
+// EndImpersonation deletes the impersonation session and returns the
+// admin's original session. ErrSessionEnded means the admin has since been
+// logged out and has to log in again.
+func (a *AuthDB) EndImpersonation(session *Session) (*Session, error) {
+	if !session.Impersonated() {
+		return nil, ErrNotImpersonating
+	}
+
+	if err := a.DeleteSession(session.ID); err != nil {
+		return nil, err
+	}
+
+	original, err := a.GetSession(session.originalSessionID)
+	if err != nil || original.UserID != session.ImpersonatorID {
+		return nil, ErrSessionEnded
+	}
+	return original, nil
+}
+
+// ImpersonationFor returns the impersonation the request is made under, or
+// nil. It works on pages that don't require a login too, so it can be used
+// for layout data.
+func (a *AuthDB) ImpersonationFor(r *http.Request) *Impersonation {
+	session := GetCurrentSession(r)
+	if session == nil {
+		cookie, err := r.Cookie("session_id")
+		if err != nil {
+			return nil
+		}
+		if session, err = a.GetSession(cookie.Value); err != nil {
+			return nil
+		}
+	}
+	if !session.Impersonated() {
+		return nil
+	}
+
+	user, err := a.GetUserByID(session.UserID)
+	if err != nil || user == nil {
+		return nil
+	}
+	impersonator, err := a.GetUserByID(session.ImpersonatorID)
+	if err != nil || impersonator == nil {
+		return nil
+	}
+
+	return &Impersonation{
+		User:         user,
+		Impersonator: impersonator,
+		ExpiresAt:    session.ExpiresAt,
+		EndURL:       ImpersonationEndURL,
+	}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// An impersonation can't outlast the admin's own access: logging the admin
+// out, disabling or deleting them ends it
+func TestImpersonationEndsWithImpersonator(t *testing.T) {
+	tests := []struct {
+		name string
+		end  func(a *AuthDB, adminID int64) error
+		kept bool // the session row survives, only RequireAuth stops it
+	}{
+		{"sessions revoked", func(a *AuthDB, adminID int64) error {
+			return a.RevokeSessions(adminID, "")
+		}, false},
+		{"disabled", func(a *AuthDB, adminID int64) error {
+			return a.DisableUser(adminID)
+		}, false},
+		{"deleted", func(a *AuthDB, adminID int64) error {
+			return a.DeleteUser(adminID)
+		}, false},
+		{"disabled behind its back", func(a *AuthDB, adminID int64) error {
+			_, err := a.db.Exec(`UPDATE users SET disabled_at = ? WHERE id = ?`, time.Now(), adminID)
+			return err
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAuthDB(t)
+			user := createTestUser(t, a, "user@example.com")
+			admin := createTestUser(t, a, "admin@example.com")
+			adminSession, err := a.CreateSession(admin.ID, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			userSession, err := a.CreateSession(user.ID, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			impersonation, err := a.StartImpersonation(adminSession, user.ID)
+			if err != nil {
+				t.Fatalf("StartImpersonation: %v", err)
+			}
+
+			if err := tt.end(a, admin.ID); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := a.GetSession(impersonation.ID); (err == nil) != tt.kept {
+				t.Errorf("impersonation session kept %v, want %v", err == nil, tt.kept)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(&http.Cookie{Name: "session_id", Value: impersonation.ID})
+			w := httptest.NewRecorder()
+			a.RequireAuth(okHandler)(w, r)
+			// sent to log in
+			if w.Code != http.StatusSeeOther {
+				t.Errorf("impersonated request: status %d, want %d", w.Code, http.StatusSeeOther)
+			}
+
+			// the user's own session has nothing to do with it
+			if _, err := a.GetSession(userSession.ID); err != nil {
+				t.Errorf("the user's own session ended: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrRegistrationClosed = errors.New("registration is closed")
+	ErrInvitationRequired = errors.New("registration needs an invitation")
+	ErrInvalidInvitation  = errors.New("invalid or expired invitation")
+)
+
+// Invitation lets someone register with the invited email address whatever
+// the registration mode, unless registration is closed
+type Invitation struct {
+	ID        int64
+	Email     string
+	InvitedBy int64
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	UsedBy    int64
+	CreatedAt time.Time
+}
+
+func (i *Invitation) Used() bool {
+	return i.UsedAt != nil
+}
+
+func (i *Invitation) Expired() bool {
+	return time.Now().After(i.ExpiresAt)
+}
+
+const invitationColumns = `id, email, invited_by, expires_at, used_at, used_by, created_at`
+
+func scanInvitation(row interface{ Scan(...any) error }) (*Invitation, error) {
+	var inv Invitation
+	var invitedBy, usedBy sql.NullInt64
+	var usedAt sql.NullTime
+
+	err := row.Scan(&inv.ID, &inv.Email, &invitedBy, &inv.ExpiresAt, &usedAt, &usedBy, &inv.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	inv.InvitedBy = invitedBy.Int64
+	inv.UsedBy = usedBy.Int64
+	if usedAt.Valid {
+		inv.UsedAt = &usedAt.Time
+	}
+	return &inv, nil
+}
+
+// CreateInvitation invites email to register and returns the token to send
+// them, it can't be recovered later. Any earlier unused invitation for the
+// same address stops working.
+func (a *AuthDB) CreateInvitation(email string, invitedBy int64) (*Invitation, string, error) {
+	if a.cfg.RegistrationMode == RegistrationClosed {
+		return nil, "", ErrRegistrationClosed
+	}
+
+	email = normalizeEmail(email)
+	if errs := a.validateEmail(email); len(errs) > 0 {
+		return nil, "", errs
+	}
+
+	token, err := generateSessionID()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, err := a.db.Exec(`DELETE FROM invitations WHERE email = ? AND used_at IS NULL`, email); err != nil {
+		return nil, "", err
+	}
+
+	inv := &Invitation{
+		Email:     email,
+		InvitedBy: invitedBy,
+		ExpiresAt: time.Now().Add(a.cfg.InvitationTTL),
+		CreatedAt: time.Now(),
+	}
+	result, err := a.db.Exec(
+		`INSERT INTO invitations (email, token_hash, invited_by, expires_at) VALUES (?, ?, ?, ?)`,
+		email, hashToken(token), nullID(invitedBy), inv.ExpiresAt,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	inv.ID, err = result.LastInsertId()
+	if err != nil {
+		return nil, "", err
+	}
+	return inv, token, nil
+}
+
+// GetInvitation returns the usable invitation for token, for filling in the
+// registration form
+func (a *AuthDB) GetInvitation(token string) (*Invitation, error) {
+	inv, err := scanInvitation(a.db.QueryRow(
+		`SELECT `+invitationColumns+` FROM invitations WHERE token_hash = ?`,
+		hashToken(token),
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+	if inv.Used() || inv.Expired() {
+		return nil, ErrInvalidInvitation
+	}
+	return inv, nil
+}
+
+// ListInvitations returns every invitation, newest first
+func (a *AuthDB) ListInvitations() ([]*Invitation, error) {
+	rows, err := a.db.Query(`SELECT ` + invitationColumns + ` FROM invitations ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*Invitation
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// RevokeInvitation deletes an invitation that hasn't been used yet
+func (a *AuthDB) RevokeInvitation(id int64) error {
+	result, err := a.db.Exec(`DELETE FROM invitations WHERE id = ? AND used_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInvalidInvitation
+	}
+	return nil
+}
+
+// CreateUserWithInvitation registers the invited user, the email must be
+// the one the invitation was sent to
+func (a *AuthDB) CreateUserWithInvitation(token, email, password, name string) (*User, error) {
+	if a.cfg.RegistrationMode == RegistrationClosed {
+		return nil, ErrRegistrationClosed
+	}
+
+	inv, err := a.GetInvitation(token)
+	if err != nil {
+		return nil, err
+	}
+	return a.createUser(email, name, &password, inv)
+}
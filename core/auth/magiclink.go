@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrRateLimited     = errors.New("too many requests, try again later")
+	ErrInvalidLink     = errors.New("invalid or expired login link")
+	ErrPasswordlessOff = errors.New("passwordless login is disabled")
+)
+
+// CreateLoginLink creates a single use login token for the user with the
+// given email, to be sent to them as a link. Requests per email are limited
+// to MagicLinkLimit every MagicLinkWindow. Returns a nil user, and no token,
+// when nobody has that email so callers can respond the same either way.
+func (a *AuthDB) CreateLoginLink(email string) (*User, string, error) {
+	if !a.cfg.Passwordless {
+		return nil, "", ErrPasswordlessOff
+	}
+
+	email = normalizeEmail(email)
+	now := time.Now().UTC()
+
+	// Rate limit before the user lookup, so it can't be used to probe
+	// which emails have accounts
+	rows, err := a.db.Query(
+		`SELECT created_at FROM login_links WHERE email = ? ORDER BY created_at DESC LIMIT ?`,
+		email, a.cfg.MagicLinkLimit,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	recent := 0
+	for rows.Next() {
+		var createdAt time.Time
+		if err := rows.Scan(&createdAt); err != nil {
+			rows.Close()
+			return nil, "", err
+		}
+		if now.Sub(createdAt) < a.cfg.MagicLinkWindow {
+			recent++
+		}
+	}
+	rows.Close()
+	if recent >= a.cfg.MagicLinkLimit {
+		return nil, "", ErrRateLimited
+	}
+
+	user, err := a.GetUserByEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := generateSessionID()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Requests for unknown emails are recorded too so they count towards
+	// the limit, they just never get a usable token back
+	_, err = a.db.Exec(
+		`INSERT INTO login_links (email, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?)`,
+		email, hashToken(token), now.Add(a.cfg.MagicLinkTTL), now,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if user == nil {
+		return nil, "", nil
+	}
+	return user, token, nil
+}
+
+// ConsumeLoginLink validates a login token and marks it used, returning the
+// user it was issued for
+func (a *AuthDB) ConsumeLoginLink(token string) (*User, error) {
+	if !a.cfg.Passwordless {
+		return nil, ErrPasswordlessOff
+	}
+
+	var id int64
+	var email string
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+
+	err := a.db.QueryRow(
+		`SELECT id, email, expires_at, used_at FROM login_links WHERE token_hash = ?`,
+		hashToken(token),
+	).Scan(&id, &email, &expiresAt, &usedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidLink
+	}
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid || time.Now().After(expiresAt) {
+		return nil, ErrInvalidLink
+	}
+
+	// Only one request can win the update, which makes the link single use
+	result, err := a.db.Exec(
+		`UPDATE login_links SET used_at = ? WHERE id = ? AND used_at IS NULL`,
+		time.Now().UTC(), id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrInvalidLink
+	}
+
+	user, err := a.GetUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidLink
+	}
+	if user.Disabled() {
+		return nil, ErrUserDisabled
+	}
+
+	// the link was sent to the user's email
+	if err := a.MarkEmailVerified(user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
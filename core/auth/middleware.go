@@ -2,43 +2,247 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/MickDuprez/gobase/core/middleware"
 )
 
 type contextKey string
 
-const UserContextKey contextKey = "user"
+const (
+	UserContextKey    contextKey = "user"
+	TokenContextKey   contextKey = "token"
+	SessionContextKey contextKey = "session"
+)
+
+// LoginURL is where unauthenticated browser requests are sent
+var LoginURL = "/login"
+
+// PasswordChangeURL is where users an admin has asked to choose a new
+// password are sent, until they do they can only use PasswordChangePaths
+var (
+	PasswordChangeURL   = "/profile?message=password_reset"
+	PasswordChangePaths = []string{"/profile", "/profile/password"}
+)
+
+// WriteError writes the error pages of this package's middleware and
+// handlers. The app sets it to its Error so they're negotiated like any other,
+// left alone it writes plain text.
+var WriteError = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+	message := http.StatusText(status)
+	if status >= 500 {
+		log.Printf("Error %d for %s %s: %v", status, r.Method, r.URL.Path, err)
+	} else if err != nil {
+		message = err.Error()
+	}
+	http.Error(w, message, status)
+}
 
-// RequireAuth middleware
+// RequireAuth middleware only accepts a session cookie. Personal access
+// tokens are for RequireToken routes, so a token can't be used to manage
+// tokens or change the account.
 func (a *AuthDB) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Check for session cookie
 		cookie, err := r.Cookie("session_id")
 		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			Unauthorized(w, r)
 			return
 		}
 
 		// Get session
 		session, err := a.GetSession(cookie.Value)
 		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			Unauthorized(w, r)
 			return
 		}
 
 		// Get user from session
 		user, err := a.GetUserByID(session.UserID)
+		if err != nil || user == nil || user.Disabled() {
+			Unauthorized(w, r)
+			return
+		}
+
+		// an impersonation ends with the admin's own access
+		if session.Impersonated() {
+			impersonator, err := a.GetUserByID(session.ImpersonatorID)
+			if err != nil || impersonator == nil || impersonator.Disabled() {
+				Unauthorized(w, r)
+				return
+			}
+		}
+
+		// someone impersonating the user isn't the one to change it
+		if user.MustChangePassword && !session.Impersonated() && !passwordChangePath(r.URL.Path) {
+			passwordChangeRequired(w, r)
+			return
+		}
+
+		// Add user and session to context
+		ctx := context.WithValue(r.Context(), UserContextKey, user)
+		ctx = context.WithValue(ctx, SessionContextKey, session)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// RequireToken middleware only accepts personal access tokens, for endpoints
+// that are meant for scripts rather than browsers
+func (a *AuthDB) RequireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := bearerToken(r)
+		if !ok {
+			tokenUnauthorized(w, "")
+			return
+		}
+
+		user, token, err := a.ValidateToken(secret)
 		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			tokenUnauthorized(w, "invalid_token")
 			return
 		}
 
-		// Add user to context
 		ctx := context.WithValue(r.Context(), UserContextKey, user)
+		ctx = context.WithValue(ctx, TokenContextKey, token)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
 
+// RefuseImpersonation middleware rejects requests from an admin
+// impersonating the user, use it inside RequireAuth on changes only the user
+// should make. Changing the email or password, creating tokens or ending
+// sessions could take over the account or outlast the impersonation.
+func RefuseImpersonation(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if session := GetCurrentSession(r); session != nil && session.Impersonated() {
+			if middleware.WantsJSON(r) {
+				writeJSONError(w, http.StatusForbidden, "impersonating")
+				return
+			}
+			WriteError(w, r, http.StatusForbidden, errors.New("You can't do this while impersonating a user."))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// RequireScope middleware rejects requests whose token wasn't granted scope,
+// use it inside RequireToken
+func RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := GetToken(r); token != nil && !token.HasScope(scope) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gobase", error="insufficient_scope", scope="`+scope+`"`)
+			writeJSONError(w, http.StatusForbidden, "insufficient_scope")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, secret, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	secret = strings.TrimSpace(secret)
+	return secret, secret != ""
+}
+
+func tokenUnauthorized(w http.ResponseWriter, code string) {
+	challenge := `Bearer realm="gobase"`
+	if code != "" {
+		challenge += `, error="` + code + `"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// Unauthorized answers a request that needs a logged in user in the way the
+// client understands: htmx gets an HX-Redirect, API clients get a 401 and
+// browsers are redirected to the login page with a return URL.
+func Unauthorized(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case middleware.IsHTMX(r):
+		// the request URL is only a fragment endpoint, return to the page
+		// the user was looking at instead
+		next := "/"
+		if current, err := url.Parse(r.Header.Get("HX-Current-URL")); err == nil {
+			next = current.RequestURI()
+		}
+		w.Header().Set("HX-Redirect", loginURLWithNext(next))
+		w.WriteHeader(http.StatusUnauthorized)
+
+	case middleware.WantsJSON(r):
+		tokenUnauthorized(w, "")
+
+	default:
+		http.Redirect(w, r, loginURLWithNext(r.URL.RequestURI()), http.StatusSeeOther)
+	}
+}
+
+func passwordChangePath(path string) bool {
+	for _, p := range PasswordChangePaths {
+		if path == p {
+			return true
+		}
+	}
+	return false
+}
+
+// passwordChangeRequired sends the user to PasswordChangeURL in the way the
+// client understands
+func passwordChangeRequired(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case middleware.IsHTMX(r):
+		w.Header().Set("HX-Redirect", PasswordChangeURL)
+		w.WriteHeader(http.StatusForbidden)
+
+	case middleware.WantsJSON(r):
+		writeJSONError(w, http.StatusForbidden, "password_change_required")
+
+	default:
+		http.Redirect(w, r, PasswordChangeURL, http.StatusSeeOther)
+	}
+}
+
+func loginURLWithNext(next string) string {
+	next = SafeRedirect(next, "")
+	if next == "" || next == "/" {
+		return LoginURL
+	}
+	return LoginURL + "?next=" + url.QueryEscape(next)
+}
+
+// SafeRedirect returns target if it is a local path on this site, otherwise
+// fallback. Use it on any user supplied return URL to avoid open redirects.
+func SafeRedirect(target, fallback string) string {
+	if target == "" || !strings.HasPrefix(target, "/") {
+		return fallback
+	}
+	// reject protocol relative urls and backslash tricks browsers accept
+	if strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") || strings.ContainsAny(target, "\r\n") {
+		return fallback
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return fallback
+	}
+	return target
+}
+
 // GetUser helper function to get user from context
 func GetUser(r *http.Request) *User {
 	user, ok := r.Context().Value(UserContextKey).(*User)
@@ -47,3 +251,45 @@ func GetUser(r *http.Request) *User {
 	}
 	return user
 }
+
+// UserFor returns the logged in user, or nil. Unlike GetUser it works on
+// pages that don't require a login too.
+func (a *AuthDB) UserFor(r *http.Request) *User {
+	if user := GetUser(r); user != nil {
+		return user
+	}
+
+	cookie, err := r.Cookie("session_id")
+	if err != nil {
+		return nil
+	}
+	session, err := a.GetSession(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	user, err := a.GetUserByID(session.UserID)
+	if err != nil || user == nil || user.Disabled() {
+		return nil
+	}
+	return user
+}
+
+// GetCurrentSession returns the session the request was authenticated with,
+// nil for token requests
+func GetCurrentSession(r *http.Request) *Session {
+	session, ok := r.Context().Value(SessionContextKey).(*Session)
+	if !ok {
+		return nil
+	}
+	return session
+}
+
+// GetToken returns the personal access token the request was authenticated
+// with, nil for session requests
+func GetToken(r *http.Request) *APIToken {
+	token, ok := r.Context().Value(TokenContextKey).(*APIToken)
+	if !ok {
+		return nil
+	}
+	return token
+}
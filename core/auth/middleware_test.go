@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestRequireAuthRejectsBearerTokens(t *testing.T) {
+	a := newTestAuthDB(t)
+	user := createTestUser(t, a, "user@example.com")
+
+	// a read only token, and a full access one without scopes
+	for _, scopes := range [][]string{{"profile:read"}, nil} {
+		_, secret, err := a.CreateToken(user.ID, "script", scopes, 0)
+		if err != nil {
+			t.Fatalf("CreateToken: %v", err)
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/profile/tokens", nil)
+		r.Header.Set("Authorization", "Bearer "+secret)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		a.RequireAuth(okHandler)(w, r)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("token with scopes %v: status %d, want %d", scopes, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestRequireAuthAcceptsSessions(t *testing.T) {
+	a := newTestAuthDB(t)
+	user := createTestUser(t, a, "user@example.com")
+
+	r, _ := sessionRequest(t, a, http.MethodGet, "/profile", user)
+	w := httptest.NewRecorder()
+	a.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if GetUser(r) == nil || GetCurrentSession(r) == nil {
+			t.Error("user and session aren't in the request context")
+		}
+		if GetToken(r) != nil {
+			t.Error("session request has a token")
+		}
+	})(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestRequireTokenScopes(t *testing.T) {
+	a := newTestAuthDB(t)
+	user := createTestUser(t, a, "user@example.com")
+
+	_, readOnly, err := a.CreateToken(user.ID, "read", []string{"profile:read"}, 0)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		scope  string
+		want   int
+	}{
+		{"granted scope", "Bearer " + readOnly, "profile:read", http.StatusOK},
+		{"missing scope", "Bearer " + readOnly, "profile:write", http.StatusForbidden},
+		{"unknown token", "Bearer gb_nope", "profile:read", http.StatusUnauthorized},
+		{"no token", "", "profile:read", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/profile", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			a.RequireToken(RequireScope(tt.scope, okHandler))(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireTokenIgnoresSessions(t *testing.T) {
+	a := newTestAuthDB(t)
+	user := createTestUser(t, a, "user@example.com")
+
+	r, _ := sessionRequest(t, a, http.MethodGet, "/api/profile", user)
+	w := httptest.NewRecorder()
+	a.RequireToken(okHandler)(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireAuthForcedPasswordChange(t *testing.T) {
+	a := newTestAuthDB(t)
+	user := createTestUser(t, a, "user@example.com")
+	if err := a.ForcePasswordReset(user.ID); err != nil {
+		t.Fatalf("ForcePasswordReset: %v", err)
+	}
+
+	tests := []struct {
+		method, target string
+		json           bool
+		want           int
+	}{
+		{http.MethodGet, "/profile", false, http.StatusOK},
+		{http.MethodPost, "/profile/password", false, http.StatusOK},
+		{http.MethodGet, "/dashboard", false, http.StatusSeeOther},
+		{http.MethodPost, "/profile/tokens", false, http.StatusSeeOther},
+		{http.MethodGet, "/dashboard", true, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r, _ := sessionRequest(t, a, tt.method, tt.target, user)
+		if tt.json {
+			r.Header.Set("Accept", "application/json")
+		}
+		w := httptest.NewRecorder()
+		a.RequireAuth(okHandler)(w, r)
+
+		if w.Code != tt.want {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.target, w.Code, tt.want)
+		}
+		if w.Code == http.StatusSeeOther && w.Header().Get("Location") != PasswordChangeURL {
+			t.Errorf("%s %s: redirected to %q, want %q", tt.method, tt.target, w.Header().Get("Location"), PasswordChangeURL)
+		}
+	}
+
+	// an admin impersonating the user can still look around
+	admin := createTestUser(t, a, "admin@example.com")
+	_, adminSession := sessionRequest(t, a, http.MethodGet, "/", admin)
+	impersonation, err := a.StartImpersonation(adminSession, user.ID)
+	if err != nil {
+		t.Fatalf("StartImpersonation: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+	r.AddCookie(&http.Cookie{Name: "session_id", Value: impersonation.ID})
+	w := httptest.NewRecorder()
+	a.RequireAuth(okHandler)(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("impersonated request: status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// once the password is changed the user can carry on
+	if err := a.ChangePassword(user.ID, testPassword, "a brand new passphrase", ""); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	r, _ = sessionRequest(t, a, http.MethodGet, "/dashboard", user)
+	w = httptest.NewRecorder()
+	a.RequireAuth(okHandler)(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("after changing password: status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestRefuseImpersonation(t *testing.T) {
+	a := newTestAuthDB(t)
+	user := createTestUser(t, a, "user@example.com")
+	admin := createTestUser(t, a, "admin@example.com")
+
+	r, adminSession := sessionRequest(t, a, http.MethodPost, "/profile/password", admin)
+	w := httptest.NewRecorder()
+	a.RequireAuth(RefuseImpersonation(okHandler))(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("own session: status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	impersonation, err := a.StartImpersonation(adminSession, user.ID)
+	if err != nil {
+		t.Fatalf("StartImpersonation: %v", err)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/profile/password", nil)
+	r.AddCookie(&http.Cookie{Name: "session_id", Value: impersonation.ID})
+	w = httptest.NewRecorder()
+	a.RequireAuth(RefuseImpersonation(okHandler))(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("impersonated session: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+// Refusals go through WriteError so the app can show its own error pages,
+// API clients still get the JSON error codes
+func TestRefusalsUseWriteError(t *testing.T) {
+	a := newTestAuthDB(t)
+	user := createTestUser(t, a, "user@example.com")
+	admin := createTestUser(t, a, "admin@example.com")
+	_, adminSession := sessionRequest(t, a, http.MethodGet, "/", admin)
+	impersonation, err := a.StartImpersonation(adminSession, user.ID)
+	if err != nil {
+		t.Fatalf("StartImpersonation: %v", err)
+	}
+
+	var written []int
+	defer func(original func(http.ResponseWriter, *http.Request, int, error)) { WriteError = original }(WriteError)
+	WriteError = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		written = append(written, status)
+		w.WriteHeader(status)
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		session string
+	}{
+		{"impersonating", a.RequireAuth(RefuseImpersonation(okHandler)), impersonation.ID},
+		{"no permission", a.RequireAuth(a.RequirePermission(PermAdmin, okHandler)), ""},
+	}
+	for _, tt := range tests {
+		for _, api := range []bool{false, true} {
+			written = nil
+			r, _ := sessionRequest(t, a, http.MethodPost, "/admin", user)
+			if tt.session != "" {
+				r = httptest.NewRequest(http.MethodPost, "/admin", nil)
+				r.AddCookie(&http.Cookie{Name: "session_id", Value: tt.session})
+			}
+			if api {
+				r.Header.Set("Accept", "application/json")
+			}
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s, api %v: status %d, want %d", tt.name, api, w.Code, http.StatusForbidden)
+			}
+			if wantWritten := !api; (len(written) == 1) != wantWritten {
+				t.Errorf("%s, api %v: WriteError called with %v, want it called %v", tt.name, api, written, wantWritten)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package oidc
+
+import (
+	"strings"
+
+	"github.com/MickDuprez/gobase/core/utils"
+)
+
+// Config describes one OpenID Connect provider
+type Config struct {
+	Name         string // used in urls, e.g. /auth/{name}/login
+	DisplayName  string // shown on the "Sign in with..." button
+	Issuer       string // issuer url, discovery is done from here
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // optional, /auth/<name>/callback on the auth BaseURL when empty
+	Scopes       []string
+}
+
+// NewConfigsFromEnv reads the providers listed in OIDC_PROVIDERS, each one
+// configured with OIDC_<NAME>_* variables, e.g.
+//
+//	OIDC_PROVIDERS=google
+//	OIDC_GOOGLE_ISSUER=https://accounts.google.com
+//	OIDC_GOOGLE_CLIENT_ID=...
+//	OIDC_GOOGLE_CLIENT_SECRET=...
+func NewConfigsFromEnv() []Config {
+	var configs []Config
+
+	for _, name := range strings.Split(utils.GetEnvStr("OIDC_PROVIDERS", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		scopes := strings.Fields(utils.GetEnvStr(prefix+"SCOPES", "openid email profile"))
+
+		configs = append(configs, Config{
+			Name:         strings.ToLower(name),
+			DisplayName:  utils.GetEnvStr(prefix+"DISPLAY_NAME", name),
+			Issuer:       utils.GetEnvStr(prefix+"ISSUER", ""),
+			ClientID:     utils.GetEnvStr(prefix+"CLIENT_ID", ""),
+			ClientSecret: utils.GetEnvStr(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  utils.GetEnvStr(prefix+"REDIRECT_URL", ""),
+			Scopes:       scopes,
+		})
+	}
+
+	return configs
+}
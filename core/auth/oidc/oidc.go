@@ -0,0 +1,313 @@
+// Package oidc adds "Sign in with..." login through OpenID Connect providers
+// using the authorization code flow with PKCE. Provider accounts are linked
+// to auth.User through the user_identities table.
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MickDuprez/gobase/core/auth"
+	"github.com/MickDuprez/gobase/core/interfaces"
+)
+
+// flowTimeout is how long the user has to complete login at the provider
+const flowTimeout = 10 * time.Minute
+
+type Manager struct {
+	auth      *auth.AuthDB
+	providers map[string]*Provider
+}
+
+// ProviderInfo is what templates need to show a login button
+type ProviderInfo struct {
+	Name        string
+	DisplayName string
+	LoginURL    string
+}
+
+func New(authDB *auth.AuthDB, configs ...Config) *Manager {
+	m := &Manager{
+		auth:      authDB,
+		providers: make(map[string]*Provider),
+	}
+	for _, cfg := range configs {
+		m.AddProvider(NewProvider(cfg))
+	}
+	return m
+}
+
+// AddProvider registers a provider, replacing any with the same name
+func (m *Manager) AddProvider(p *Provider) {
+	m.providers[p.Name] = p
+}
+
+// Providers lists the configured providers, register it as a template helper
+// to render login buttons
+func (m *Manager) Providers() []ProviderInfo {
+	infos := make([]ProviderInfo, 0, len(m.providers))
+	for _, p := range m.providers {
+		infos = append(infos, ProviderInfo{
+			Name:        p.Name,
+			DisplayName: p.DisplayName,
+			LoginURL:    "/auth/" + p.Name + "/login",
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].DisplayName < infos[j].DisplayName })
+	return infos
+}
+
+// Routes sets up the login and callback routes for all providers
+func (m *Manager) Routes(app interfaces.App) {
+	app.Handle("GET /auth/{provider}/login", m.login)
+	app.Handle("GET /auth/{provider}/callback", m.callback)
+}
+
+// flowState is kept in a short lived cookie between login and callback
+type flowState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Next     string `json:"next"`
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func stateCookieName(provider string) string {
+	return "oidc_" + provider
+}
+
+// redirectURL is where the provider sends the user back to, on the
+// configured base URL rather than the request's Host header
+func (m *Manager) redirectURL(p *Provider) string {
+	if p.RedirectURL != "" {
+		return p.RedirectURL
+	}
+	return m.auth.AbsoluteURL("/auth/" + p.Name + "/callback")
+}
+
+func (m *Manager) login(w http.ResponseWriter, r *http.Request) {
+	p, ok := m.providers[r.PathValue("provider")]
+	if !ok {
+		auth.WriteError(w, r, http.StatusNotFound, nil)
+		return
+	}
+
+	var flow flowState
+	var err error
+	if flow.State, err = randomString(); err != nil {
+		auth.WriteError(w, r, http.StatusInternalServerError, fmt.Errorf("failed to start login: %w", err))
+		return
+	}
+	if flow.Nonce, err = randomString(); err != nil {
+		auth.WriteError(w, r, http.StatusInternalServerError, fmt.Errorf("failed to start login: %w", err))
+		return
+	}
+	if flow.Verifier, err = randomString(); err != nil {
+		auth.WriteError(w, r, http.StatusInternalServerError, fmt.Errorf("failed to start login: %w", err))
+		return
+	}
+	flow.Next = auth.SafeRedirect(r.URL.Query().Get("next"), "/")
+
+	target, err := p.AuthCodeURL(r.Context(), m.redirectURL(p), flow.State, flow.Nonce, pkceChallenge(flow.Verifier))
+	if err != nil {
+		log.Printf("OIDC %s: %v", p.Name, err)
+		http.Redirect(w, r, "/login?error=provider_unavailable", http.StatusSeeOther)
+		return
+	}
+
+	value, err := json.Marshal(flow)
+	if err != nil {
+		auth.WriteError(w, r, http.StatusInternalServerError, fmt.Errorf("failed to start login: %w", err))
+		return
+	}
+
+	// Lax rather than Strict, the callback is a cross site navigation
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName(p.Name),
+		Value:    base64.RawURLEncoding.EncodeToString(value),
+		Path:     "/auth/" + p.Name + "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(flowTimeout.Seconds()),
+	})
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func (m *Manager) callback(w http.ResponseWriter, r *http.Request) {
+	p, ok := m.providers[r.PathValue("provider")]
+	if !ok {
+		auth.WriteError(w, r, http.StatusNotFound, nil)
+		return
+	}
+
+	flow, err := readFlowState(r, p.Name)
+	// the state cookie is single use
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName(p.Name),
+		Path:     "/auth/" + p.Name + "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+	if err != nil {
+		log.Printf("OIDC %s: %v", p.Name, err)
+		http.Redirect(w, r, "/login?error=login_expired", http.StatusSeeOther)
+		return
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		log.Printf("OIDC %s: provider returned %s: %s", p.Name, e, q.Get("error_description"))
+		http.Redirect(w, r, "/login?error=provider_denied", http.StatusSeeOther)
+		return
+	}
+	if q.Get("state") != flow.State {
+		log.Printf("OIDC %s: state mismatch", p.Name)
+		http.Redirect(w, r, "/login?error=login_expired", http.StatusSeeOther)
+		return
+	}
+
+	rawIDToken, err := p.Exchange(r.Context(), q.Get("code"), m.redirectURL(p), flow.Verifier)
+	if err != nil {
+		log.Printf("OIDC %s: %v", p.Name, err)
+		http.Redirect(w, r, "/login?error=provider_unavailable", http.StatusSeeOther)
+		return
+	}
+
+	claims, err := p.VerifyIDToken(r.Context(), rawIDToken, flow.Nonce)
+	if err != nil {
+		log.Printf("OIDC %s: %v", p.Name, err)
+		m.auth.Audit(r, auth.AuditEvent{Type: auth.AuditLoginFailure, Details: p.Name + ": " + err.Error()})
+		http.Redirect(w, r, "/login?error=invalid_credentials", http.StatusSeeOther)
+		return
+	}
+
+	user, err := m.userForClaims(p.Name, claims)
+	if err != nil {
+		log.Printf("OIDC %s: %v", p.Name, err)
+		m.auth.Audit(r, auth.AuditEvent{Type: auth.AuditLoginFailure, Email: claims.Email, Details: p.Name + ": " + err.Error()})
+		http.Redirect(w, r, "/login?error=account_not_linked", http.StatusSeeOther)
+		return
+	}
+
+	session, err := m.auth.CreateSession(user.ID, 24*time.Hour)
+	if err != nil {
+		auth.WriteError(w, r, http.StatusInternalServerError, fmt.Errorf("failed to create session: %w", err))
+		return
+	}
+	auth.SetSessionCookie(w, session)
+	m.auth.Audit(r, auth.AuditEvent{Type: auth.AuditLoginSuccess, UserID: user.ID, Email: user.Email, Details: p.Name})
+
+	// The session cookie is SameSite=Strict and we got here through a cross
+	// site redirect, so a plain redirect would arrive without it. Navigate
+	// from our own page instead.
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	redirectPage.Execute(w, flow.Next)
+}
+
+var redirectPage = template.Must(template.New("redirect").Parse(
+	`<!DOCTYPE html><html><head><meta http-equiv="refresh" content="0;url={{.}}"></head>` +
+		`<body><a href="{{.}}">Continue</a></body></html>`,
+))
+
+func readFlowState(r *http.Request, provider string) (*flowState, error) {
+	cookie, err := r.Cookie(stateCookieName(provider))
+	if err != nil {
+		return nil, errors.New("no login in progress")
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state cookie: %w", err)
+	}
+
+	var flow flowState
+	if err := json.Unmarshal(value, &flow); err != nil {
+		return nil, fmt.Errorf("invalid state cookie: %w", err)
+	}
+	if flow.State == "" || flow.Verifier == "" {
+		return nil, errors.New("invalid state cookie")
+	}
+	flow.Next = auth.SafeRedirect(flow.Next, "/")
+	return &flow, nil
+}
+
+// userForClaims finds the user linked to the provider account. A new
+// provider account is linked to the user with the same email, or a new user
+// is created for it, only when the provider has verified the email.
+// Otherwise anyone could claim an address at a provider and take the
+// account, or the address, of its owner.
+func (m *Manager) userForClaims(provider string, claims *Claims) (*auth.User, error) {
+	user, err := m.auth.GetUserByIdentity(provider, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		if user.Disabled() {
+			return nil, auth.ErrUserDisabled
+		}
+		return user, nil
+	}
+
+	if claims.Email == "" {
+		return nil, errors.New("provider did not return an email")
+	}
+	if !claims.EmailVerified {
+		return nil, fmt.Errorf("provider has not verified email %s", claims.Email)
+	}
+
+	user, err = m.auth.GetUserByEmail(claims.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if user != nil {
+		if user.Disabled() {
+			return nil, auth.ErrUserDisabled
+		}
+	} else {
+		name := claims.Name
+		if name == "" {
+			name = strings.Split(claims.Email, "@")[0]
+		}
+
+		// the user logs in through the provider
+		user, err = m.auth.CreateUserWithoutPassword(claims.Email, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := m.auth.LinkIdentity(user.ID, provider, claims.Subject, claims.Email); err != nil {
+		return nil, err
+	}
+	if err := m.auth.MarkEmailVerified(user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
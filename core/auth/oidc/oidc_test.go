@@ -0,0 +1,401 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MickDuprez/gobase/core/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testClientID    = "gobase-test"
+	testRedirectURL = "http://app.test/auth/test/callback"
+)
+
+// testProvider is a stand-in OpenID Connect provider serving discovery,
+// authorization, token and key endpoints
+type testProvider struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey // published in the key set
+
+	mu sync.Mutex
+	// signingKey signs ID tokens, swap it to sign with an unknown key
+	signingKey *rsa.PrivateKey
+	// claims changes the ID token claims before they are signed
+	claims func(claims map[string]interface{})
+	codes  map[string]grant
+}
+
+// grant is what the provider remembers about an authorization code
+type grant struct {
+	challenge, nonce string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := &testProvider{key: key, signingKey: key, codes: make(map[string]grant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", tp.discovery)
+	mux.HandleFunc("GET /authorize", tp.authorize)
+	mux.HandleFunc("POST /token", tp.token)
+	mux.HandleFunc("GET /jwks", tp.jwks)
+	tp.srv = httptest.NewServer(mux)
+	t.Cleanup(tp.srv.Close)
+	return tp
+}
+
+func (tp *testProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 tp.srv.URL,
+		"authorization_endpoint": tp.srv.URL + "/authorize",
+		"token_endpoint":         tp.srv.URL + "/token",
+		"jwks_uri":               tp.srv.URL + "/jwks",
+	})
+}
+
+// authorize logs the user straight in and sends them back with a code
+func (tp *testProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testClientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	tp.mu.Lock()
+	tp.codes[code] = grant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	tp.mu.Unlock()
+
+	back := url.Values{"code": {code}, "state": {q.Get("state")}}
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+back.Encode(), http.StatusFound)
+}
+
+func (tp *testProvider) token(w http.ResponseWriter, r *http.Request) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	code := r.PostFormValue("code")
+	g, ok := tp.codes[code]
+	delete(tp.codes, code)
+	if !ok {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	if pkceChallenge(r.PostFormValue("code_verifier")) != g.challenge {
+		http.Error(w, `{"error":"invalid_grant","error_description":"PKCE verification failed"}`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            tp.srv.URL,
+		"sub":            "subject-1",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          g.nonce,
+		"email":          "ann@example.com",
+		"email_verified": true,
+		"name":           "Ann",
+	}
+	if tp.claims != nil {
+		tp.claims(claims)
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"id_token": sign(tp.signingKey, claims)})
+}
+
+func (tp *testProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := tp.key.PublicKey
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test-key",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func sign(key *rsa.PrivateKey, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// newTestManager returns a manager for the stand-in provider, using an
+// AuthDB in a temporary directory
+func newTestManager(t *testing.T, tp *testProvider) *Manager {
+	t.Helper()
+
+	// NewAuthDB opens data/auth.db in the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	authDB, err := auth.NewAuthDB(&auth.Config{
+		BaseURL:          "http://app.test",
+		Hasher:           auth.NewBcryptHasher(bcrypt.MinCost),
+		PasswordPolicy:   &auth.PasswordPolicy{MinLength: 8},
+		RegistrationMode: auth.RegistrationOpen,
+	})
+	if err != nil {
+		t.Fatalf("NewAuthDB: %v", err)
+	}
+	t.Cleanup(func() { authDB.Close() })
+
+	return New(authDB, Config{
+		Name:        "test",
+		DisplayName: "Test",
+		Issuer:      tp.srv.URL,
+		ClientID:    testClientID,
+		// no RedirectURL, it's testRedirectURL on the base URL whatever
+		// Host the requests have
+		Scopes: []string{"openid", "email", "profile"},
+	})
+}
+
+// loginFlow goes through login at the stand-in provider and returns the
+// response to the callback. tamper, if not nil, can change the callback's
+// query and the state cookie's flow first.
+func loginFlow(t *testing.T, m *Manager, tamper func(q url.Values, flow *flowState)) *httptest.ResponseRecorder {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /auth/{provider}/login", m.login)
+	mux.HandleFunc("GET /auth/{provider}/callback", m.callback)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/test/login?next=/dashboard", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login returned %d, want %d", w.Code, http.StatusFound)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != stateCookieName("test") {
+		t.Fatalf("login set cookies %v, want the state cookie", cookies)
+	}
+	stateCookie := cookies[0]
+
+	// the browser goes to the provider, which sends it back with a code
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(back.String(), testRedirectURL) {
+		t.Fatalf("provider redirected to %q, want the callback", resp.Header.Get("Location"))
+	}
+
+	q := back.Query()
+	if tamper != nil {
+		flow, err := readFlowState(&http.Request{Header: http.Header{"Cookie": {stateCookie.String()}}}, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tamper(q, flow)
+		value, _ := json.Marshal(flow)
+		stateCookie.Value = base64.RawURLEncoding.EncodeToString(value)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/test/callback?"+q.Encode(), nil)
+	r.AddCookie(stateCookie)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestLogin(t *testing.T) {
+	tp := newTestProvider(t)
+	m := newTestManager(t, tp)
+
+	w := loginFlow(t, m, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback returned %d (%s), want %d", w.Code, w.Header().Get("Location"), http.StatusOK)
+	}
+	if !strings.Contains(w.Body.String(), `url=/dashboard`) {
+		t.Errorf("callback doesn't continue to next: %s", w.Body)
+	}
+	var session *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "session_id" && c.Value != "" {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("callback didn't set a session cookie")
+	}
+
+	user, err := m.auth.GetUserByIdentity("test", "subject-1")
+	if err != nil || user == nil {
+		t.Fatalf("GetUserByIdentity = %v, %v, want the new user", user, err)
+	}
+	if user.Email != "ann@example.com" || user.Name != "Ann" {
+		t.Errorf("user is %q <%s>, want Ann <ann@example.com>", user.Name, user.Email)
+	}
+
+	// logging in again uses the same account
+	if w := loginFlow(t, m, nil); w.Code != http.StatusOK {
+		t.Fatalf("second callback returned %d (%s)", w.Code, w.Header().Get("Location"))
+	}
+	again, err := m.auth.GetUserByIdentity("test", "subject-1")
+	if err != nil || again == nil || again.ID != user.ID {
+		t.Errorf("second login got user %v, %v, want %d", again, err, user.ID)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims func(claims map[string]interface{})
+		tamper func(q url.Values, flow *flowState)
+		// otherKey signs the ID token with a key the provider didn't publish
+		otherKey bool
+		want     string
+	}{
+		{
+			name:   "state mismatch",
+			tamper: func(q url.Values, flow *flowState) { q.Set("state", "forged") },
+			want:   "login_expired",
+		},
+		{
+			name:   "pkce mismatch",
+			tamper: func(q url.Values, flow *flowState) { flow.Verifier = "not-the-verifier" },
+			want:   "provider_unavailable",
+		},
+		{
+			name:   "provider error",
+			tamper: func(q url.Values, flow *flowState) { q.Set("error", "access_denied") },
+			want:   "provider_denied",
+		},
+		{
+			name:     "bad signature",
+			otherKey: true,
+			want:     "invalid_credentials",
+		},
+		{
+			name:   "wrong audience",
+			claims: func(c map[string]interface{}) { c["aud"] = "another-client" },
+			want:   "invalid_credentials",
+		},
+		{
+			name:   "wrong issuer",
+			claims: func(c map[string]interface{}) { c["iss"] = "https://issuer.invalid" },
+			want:   "invalid_credentials",
+		},
+		{
+			name:   "expired",
+			claims: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+			want:   "invalid_credentials",
+		},
+		{
+			name:   "nonce mismatch",
+			claims: func(c map[string]interface{}) { c["nonce"] = "replayed" },
+			want:   "invalid_credentials",
+		},
+		{
+			name:   "unverified email",
+			claims: func(c map[string]interface{}) { c["email_verified"] = false },
+			want:   "account_not_linked",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp := newTestProvider(t)
+			tp.claims = tt.claims
+			if tt.otherKey {
+				key, err := rsa.GenerateKey(rand.Reader, 2048)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tp.signingKey = key
+			}
+			m := newTestManager(t, tp)
+
+			w := loginFlow(t, m, tt.tamper)
+			want := "/login?error=" + tt.want
+			if w.Code != http.StatusSeeOther || w.Header().Get("Location") != want {
+				t.Errorf("callback returned %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), http.StatusSeeOther, want)
+			}
+			for _, c := range w.Result().Cookies() {
+				if c.Name == "session_id" {
+					t.Errorf("callback set a session cookie")
+				}
+			}
+
+			user, err := m.auth.GetUserByEmail("ann@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user != nil {
+				t.Errorf("a user was created for ann@example.com")
+			}
+		})
+	}
+}
+
+// An existing user is only linked when the provider verified the email
+func TestLoginLinksVerifiedEmail(t *testing.T) {
+	tp := newTestProvider(t)
+	m := newTestManager(t, tp)
+
+	user, err := m.auth.CreateUser("ann@example.com", "correct horse battery staple", "Ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp.claims = func(c map[string]interface{}) { c["email_verified"] = false }
+	if w := loginFlow(t, m, nil); w.Header().Get("Location") != "/login?error=account_not_linked" {
+		t.Fatalf("unverified email: callback returned %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if linked, _ := m.auth.GetUserByIdentity("test", "subject-1"); linked != nil {
+		t.Fatalf("unverified email was linked to user %d", linked.ID)
+	}
+
+	tp.claims = nil
+	if w := loginFlow(t, m, nil); w.Code != http.StatusOK {
+		t.Fatalf("verified email: callback returned %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	linked, err := m.auth.GetUserByIdentity("test", "subject-1")
+	if err != nil || linked == nil || linked.ID != user.ID {
+		t.Errorf("verified email linked to %v, %v, want user %d", linked, err, user.ID)
+	}
+}
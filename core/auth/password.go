@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes passwords for storage. Hashes are self describing,
+// they record the algorithm and parameters used, so a hash stays verifiable
+// after the configured hasher changes.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash, password string) (bool, error)
+	// NeedsRehash reports whether hash wasn't made by this hasher with its
+	// current parameters
+	NeedsRehash(hash string) bool
+}
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// bcryptMaxBytes is the longest password bcrypt hashes, in bytes
+const bcryptMaxBytes = 72
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+	return &BcryptHasher{Cost: cost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher stores hashes in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type Argon2idHasher struct {
+	Memory      uint32 // in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Default Argon2id parameters
+const (
+	defaultArgon2Memory      = 64 * 1024
+	defaultArgon2Iterations  = 3
+	defaultArgon2Parallelism = 2
+)
+
+// NewArgon2idHasher returns a hasher with the given memory in KiB, number
+// of iterations and parallelism. Out of range parameters fall back to the
+// defaults, as an out of range bcrypt cost does, rather than making every
+// hash panic or wrap around.
+func NewArgon2idHasher(memory, iterations, parallelism int) *Argon2idHasher {
+	if parallelism < 1 || parallelism > 255 {
+		parallelism = defaultArgon2Parallelism
+	}
+	if iterations < 1 {
+		iterations = defaultArgon2Iterations
+	}
+	// argon2 needs at least 8 KiB per lane, 4 GiB is plenty for anyone
+	if memory < 8*parallelism || memory > 4*1024*1024 {
+		memory = defaultArgon2Memory
+	}
+
+	return &Argon2idHasher{
+		Memory:      uint32(memory),
+		Iterations:  uint32(iterations),
+		Parallelism: uint8(parallelism),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func parseArgon2id(hash string) (*argon2Params, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, ErrUnknownHash
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	var p argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return nil, ErrUnknownHash
+	}
+	// argon2 panics on these
+	if p.iterations < 1 || p.parallelism < 1 {
+		return nil, ErrUnknownHash
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	return &p, nil
+}
+
+func (h *Argon2idHasher) Verify(hash, password string) (bool, error) {
+	p, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), p.salt, p.iterations, p.memory, p.parallelism, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	p, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return p.memory != h.Memory || p.iterations != h.Iterations || p.parallelism != h.Parallelism ||
+		uint32(len(p.salt)) != h.SaltLength || uint32(len(p.key)) != h.KeyLength
+}
+
+// verifyPassword checks password against a hash made by any of the built in
+// hashers, whichever one is configured now. Other formats are left to the
+// configured hasher.
+func (a *AuthDB) verifyPassword(hash, password string) (bool, error) {
+	switch {
+	case hash == "":
+		// the user has no password
+		return false, nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return (&Argon2idHasher{}).Verify(hash, password)
+	case strings.HasPrefix(hash, "$2"):
+		return (&BcryptHasher{}).Verify(hash, password)
+	default:
+		return a.cfg.Hasher.Verify(hash, password)
+	}
+}
+
+// rehashPassword stores a new hash for the user if their current one was
+// made with settings other than the configured ones
+func (a *AuthDB) rehashPassword(user *User, password string) error {
+	if !a.cfg.Hasher.NeedsRehash(user.PasswordHash) {
+		return nil
+	}
+
+	hash, err := a.cfg.Hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	// only replace the hash we verified against, in case the password was
+	// changed in the meantime
+	_, err = a.db.Exec(
+		`UPDATE users SET password_hash = ? WHERE id = ? AND password_hash = ?`,
+		hash, user.ID, user.PasswordHash,
+	)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = hash
+	return nil
+}
+
+func newPasswordHasher(name string, bcryptCost, memory, iterations, parallelism int) PasswordHasher {
+	if name == "argon2id" {
+		return NewArgon2idHasher(memory, iterations, parallelism)
+	}
+	return NewBcryptHasher(bcryptCost)
+}
@@ -0,0 +1,61 @@
+package auth
+
+import "testing"
+
+func TestNewArgon2idHasherParameters(t *testing.T) {
+	tests := []struct {
+		name                         string
+		memory, iterations, parallel int
+		wantMemory, wantIterations   uint32
+		wantParallelism              uint8
+	}{
+		{"valid", 16 * 1024, 2, 4, 16 * 1024, 2, 4},
+		{"zero parallelism", 16 * 1024, 2, 0, 16 * 1024, 2, defaultArgon2Parallelism},
+		{"parallelism over 255", 16 * 1024, 2, 256, 16 * 1024, 2, defaultArgon2Parallelism},
+		{"zero iterations", 16 * 1024, 0, 1, 16 * 1024, defaultArgon2Iterations, 1},
+		{"negative memory", -1, 1, 1, defaultArgon2Memory, 1, 1},
+		{"too little memory for the lanes", 8 * 4, 1, 8, defaultArgon2Memory, 1, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewArgon2idHasher(tt.memory, tt.iterations, tt.parallel)
+			if h.Memory != tt.wantMemory || h.Iterations != tt.wantIterations || h.Parallelism != tt.wantParallelism {
+				t.Errorf("NewArgon2idHasher(%d, %d, %d) = m=%d,t=%d,p=%d, want m=%d,t=%d,p=%d",
+					tt.memory, tt.iterations, tt.parallel, h.Memory, h.Iterations, h.Parallelism,
+					tt.wantMemory, tt.wantIterations, tt.wantParallelism)
+			}
+		})
+	}
+}
+
+func TestArgon2idHasher(t *testing.T) {
+	h := NewArgon2idHasher(8*1024, 1, 0)
+
+	hash, err := h.Hash(testPassword)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if ok, err := h.Verify(hash, testPassword); !ok || err != nil {
+		t.Errorf("Verify(right password) = %v, %v, want true", ok, err)
+	}
+	if ok, err := h.Verify(hash, "wrong password"); ok || err != nil {
+		t.Errorf("Verify(wrong password) = %v, %v, want false", ok, err)
+	}
+	if h.NeedsRehash(hash) {
+		t.Error("NeedsRehash is true for a hash made with the same parameters")
+	}
+	if !NewArgon2idHasher(8*1024, 2, 2).NeedsRehash(hash) {
+		t.Error("NeedsRehash is false after the parameters changed")
+	}
+
+	// stored parameters that would make argon2 panic are rejected
+	for _, bad := range []string{
+		"$argon2id$v=19$m=8192,t=1,p=0$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+		"$argon2id$v=19$m=8192,t=0,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+	} {
+		if _, err := h.Verify(bad, testPassword); err == nil {
+			t.Errorf("Verify(%q) succeeded, want an error", bad)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultPasswordMaxLength is the most characters a password can have when
+// the policy doesn't say, plenty for a passphrase
+const DefaultPasswordMaxLength = 128
+
+// PasswordPolicy decides which passwords CreateUser accepts
+type PasswordPolicy struct {
+	MinLength int
+	// MaxLength is the most characters allowed, 0 for
+	// DefaultPasswordMaxLength. Long passwords are refused before their
+	// strength is worked out, so they can't tie up the server.
+	MaxLength int
+	// MaxBytes is the most bytes allowed, 0 for no limit. bcrypt can't hash
+	// more than 72, so NewAuthDB sets this when it's the hasher.
+	MaxBytes int
+	// MinStrength is the lowest PasswordStrength score allowed, 0 to 4
+	MinStrength int
+	// DisallowPersonal rejects passwords containing the user's email or name
+	DisallowPersonal bool
+	// Breached is checked when set, see BreachedPasswords
+	Breached *BreachedPasswords
+}
+
+// Validate checks password for a user with the given email and name,
+// returning any problems as ValidationErrors on the "password" field
+func (p *PasswordPolicy) Validate(password, email, name string) ValidationErrors {
+	var errs ValidationErrors
+
+	maxLength := p.MaxLength
+	if maxLength <= 0 {
+		maxLength = DefaultPasswordMaxLength
+	}
+	if length := utf8.RuneCountInString(password); length > maxLength {
+		errs.Add("password", fmt.Sprintf("Password must be at most %d characters", maxLength))
+		return errs
+	} else if length < p.MinLength {
+		errs.Add("password", fmt.Sprintf("Password must be at least %d characters", p.MinLength))
+		return errs
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		errs.Add("password", fmt.Sprintf("Password must be at most %d characters, fewer with accented letters or symbols", p.MaxBytes))
+		return errs
+	}
+
+	if p.DisallowPersonal && containsPersonalInfo(password, email, name) {
+		errs.Add("password", "Password must not contain your email or name")
+		return errs
+	}
+
+	if PasswordStrength(password) < p.MinStrength {
+		errs.Add("password", "Password is too easy to guess, try a longer phrase or fewer common words")
+		return errs
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			// a missing dataset shouldn't stop people registering
+			log.Printf("Breached password check failed: %v", err)
+		} else if breached {
+			errs.Add("password", "This password has appeared in a data breach, choose another")
+		}
+	}
+
+	return errs
+}
+
+func containsPersonalInfo(password, email, name string) bool {
+	password = strings.ToLower(password)
+
+	parts := strings.Fields(strings.ToLower(name))
+	if local, _, ok := strings.Cut(strings.ToLower(email), "@"); ok {
+		parts = append(parts, local)
+	}
+
+	for _, part := range parts {
+		if len(part) >= 3 && strings.Contains(password, part) {
+			return true
+		}
+	}
+	return false
+}
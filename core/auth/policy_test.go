@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasswordPolicyLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		wantErr  bool
+	}{
+		{"too short", PasswordPolicy{MinLength: 8}, "short", true},
+		{"long enough", PasswordPolicy{MinLength: 8}, testPassword, false},
+		{"default maximum", PasswordPolicy{}, strings.Repeat("a", DefaultPasswordMaxLength), false},
+		{"over the default maximum", PasswordPolicy{}, strings.Repeat("a", DefaultPasswordMaxLength+1), true},
+		{"own maximum", PasswordPolicy{MaxLength: 20}, strings.Repeat("a", 21), true},
+		// counted in characters, not bytes
+		{"multibyte characters", PasswordPolicy{MaxLength: 20}, strings.Repeat("é", 20), false},
+		{"at the byte limit", PasswordPolicy{MaxBytes: 72}, strings.Repeat("é", 36), false},
+		{"over the byte limit", PasswordPolicy{MaxBytes: 72}, strings.Repeat("é", 37), true},
+	}
+	for _, tt := range tests {
+		errs := tt.policy.Validate(tt.password, "", "")
+		if (len(errs) > 0) != tt.wantErr {
+			t.Errorf("%s: Validate = %v, want error %v", tt.name, errs, tt.wantErr)
+		}
+	}
+}
+
+// Huge passwords are refused before their strength is worked out, and
+// working it out doesn't grow with the square of the length anyway
+func TestLongPasswordsAreQuick(t *testing.T) {
+	long := strings.Repeat("password", 500)
+
+	start := time.Now()
+	policy := &PasswordPolicy{MinLength: 8, MinStrength: 2}
+	if errs := policy.Validate(long, "", ""); len(errs) == 0 {
+		t.Error("a 4000 character password was accepted")
+	}
+	PasswordStrength(long)
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("checking a 4000 character password took %v", took)
+	}
+}
+
+func TestPasswordStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"password", 0},
+		{"p@ssw0rd", 0},
+		{"qwertyuiop", 0},
+		{"aaaaaaaaaa", 0},
+		{"monkey2019", 1},
+		{"correct horse battery staple", 4},
+	}
+	for _, tt := range tests {
+		if got := PasswordStrength(tt.password); got != tt.want {
+			t.Errorf("PasswordStrength(%q) = %d, want %d", tt.password, got, tt.want)
+		}
+	}
+}
+
+// A password too long for bcrypt is a validation error, not a failure to
+// hash it
+func TestBcryptPasswordTooLong(t *testing.T) {
+	a := newTestAuthDB(t)
+	long := strings.Repeat("correct horse battery staple ", 3) // 87 bytes
+
+	_, err := a.CreateUser("user@example.com", long, "User")
+	var invalid ValidationErrors
+	if !errors.As(err, &invalid) || len(invalid) != 1 || invalid[0].Field != "password" {
+		t.Fatalf("CreateUser with an 87 byte password: %v, want a password validation error", err)
+	}
+
+	user := createTestUser(t, a, "user@example.com")
+	err = a.ChangePassword(user.ID, testPassword, long, "")
+	if !errors.As(err, &invalid) || len(invalid) != 1 || invalid[0].Field != "password" {
+		t.Fatalf("ChangePassword to an 87 byte password: %v, want a password validation error", err)
+	}
+}
@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ProfileFieldType int
+
+const (
+	ProfileString ProfileFieldType = iota
+	ProfileText                    // multi line string
+	ProfileURL
+	ProfileInt
+	ProfileBool
+)
+
+// ProfileField describes one piece of profile data a feature stores for its
+// users. Fields are namespaced by the feature that registers them, so two
+// features can both have a "bio" without clashing.
+type ProfileField struct {
+	Key       string
+	Label     string
+	Type      ProfileFieldType
+	Required  bool
+	MaxLength int // for the string types, 0 is unlimited
+	// Validate is optional extra checking of the parsed value
+	Validate func(value interface{}) error
+
+	feature string
+}
+
+// Feature returns the name of the feature the field belongs to
+func (f ProfileField) Feature() string {
+	return f.feature
+}
+
+func profileKey(feature, key string) string {
+	return feature + "." + key
+}
+
+// RegisterProfileFields adds fields to the profile schema for a feature
+func (a *AuthDB) RegisterProfileFields(feature string, fields ...ProfileField) error {
+	a.profileMu.Lock()
+	defer a.profileMu.Unlock()
+
+	for _, f := range fields {
+		if f.Key == "" || strings.Contains(f.Key, ".") {
+			return fmt.Errorf("invalid profile field key %q", f.Key)
+		}
+		k := profileKey(feature, f.Key)
+		if _, exists := a.profileFields[k]; exists {
+			return fmt.Errorf("profile field %s already registered", k)
+		}
+		f.feature = feature
+		if f.Label == "" {
+			f.Label = f.Key
+		}
+		a.profileFields[k] = f
+	}
+	return nil
+}
+
+// ProfileFields returns the fields a feature registered, in key order
+func (a *AuthDB) ProfileFields(feature string) []ProfileField {
+	a.profileMu.RLock()
+	defer a.profileMu.RUnlock()
+
+	var fields []ProfileField
+	for _, f := range a.profileFields {
+		if f.feature == feature {
+			fields = append(fields, f)
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Key < fields[j].Key })
+	return fields
+}
+
+func (a *AuthDB) profileField(feature, key string) (ProfileField, bool) {
+	a.profileMu.RLock()
+	defer a.profileMu.RUnlock()
+	f, ok := a.profileFields[profileKey(feature, key)]
+	return f, ok
+}
+
+// Profile holds a user's profile data for every feature
+type Profile struct {
+	UserID int64
+	values map[string]interface{}
+}
+
+// GetProfile loads everything stored in the user's profile
+func (a *AuthDB) GetProfile(userID int64) (*Profile, error) {
+	rows, err := a.db.Query(`SELECT key, value FROM user_profiles WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	p := &Profile{UserID: userID, values: make(map[string]interface{})}
+	for rows.Next() {
+		var key, raw string
+		if err := rows.Scan(&key, &raw); err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(raw), &v); err != nil {
+			return nil, fmt.Errorf("invalid profile value for %s: %w", key, err)
+		}
+		p.values[key] = v
+	}
+	return p, rows.Err()
+}
+
+// Get returns the raw value stored for a feature's field
+func (p *Profile) Get(feature, key string) (interface{}, bool) {
+	v, ok := p.values[profileKey(feature, key)]
+	return v, ok
+}
+
+func (p *Profile) String(feature, key string) string {
+	v, _ := p.Get(feature, key)
+	s, _ := v.(string)
+	return s
+}
+
+func (p *Profile) Int(feature, key string) int64 {
+	v, _ := p.Get(feature, key)
+	// JSON numbers decode as float64
+	f, _ := v.(float64)
+	return int64(f)
+}
+
+func (p *Profile) Bool(feature, key string) bool {
+	v, _ := p.Get(feature, key)
+	b, _ := v.(bool)
+	return b
+}
+
+// Values returns a feature's part of the profile keyed by field key, handy
+// as template data
+func (p *Profile) Values(feature string) map[string]interface{} {
+	values := make(map[string]interface{})
+	prefix := feature + "."
+	for k, v := range p.values {
+		if strings.HasPrefix(k, prefix) {
+			values[strings.TrimPrefix(k, prefix)] = v
+		}
+	}
+	return values
+}
+
+// parse converts form input to the field's type and validates it
+func (f ProfileField) parse(input string) (interface{}, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		if f.Required {
+			return nil, fmt.Errorf("%s is required", f.Label)
+		}
+		if f.Type == ProfileBool {
+			return false, nil
+		}
+		return nil, nil
+	}
+
+	var value interface{}
+	switch f.Type {
+	case ProfileString, ProfileText:
+		if f.MaxLength > 0 && len([]rune(input)) > f.MaxLength {
+			return nil, fmt.Errorf("%s must be at most %d characters", f.Label, f.MaxLength)
+		}
+		value = input
+
+	case ProfileURL:
+		u, err := url.Parse(input)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%s must be a http or https url", f.Label)
+		}
+		value = input
+
+	case ProfileInt:
+		n, err := strconv.ParseInt(input, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a whole number", f.Label)
+		}
+		value = n
+
+	case ProfileBool:
+		b, err := strconv.ParseBool(input)
+		if err != nil {
+			// checkboxes send "on"
+			b = input == "on"
+		}
+		value = b
+	}
+
+	if f.Validate != nil {
+		if err := f.Validate(value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// SaveProfile validates and stores form input for a feature's profile fields.
+// Keys that aren't registered are rejected, empty values remove the field.
+func (a *AuthDB) SaveProfile(userID int64, feature string, input map[string]string) error {
+	var errs ValidationErrors
+	values := make(map[string]interface{})
+
+	for key, raw := range input {
+		f, ok := a.profileField(feature, key)
+		if !ok {
+			errs.Add(key, "Unknown profile field")
+			continue
+		}
+		v, err := f.parse(raw)
+		if err != nil {
+			errs.Add(key, err.Error())
+			continue
+		}
+		values[key] = v
+	}
+
+	// required fields must be sent too
+	for _, f := range a.ProfileFields(feature) {
+		if _, sent := input[f.Key]; f.Required && !sent {
+			errs.Add(f.Key, fmt.Sprintf("%s is required", f.Label))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for key, v := range values {
+		k := profileKey(feature, key)
+		if v == nil {
+			if _, err := tx.Exec(`DELETE FROM user_profiles WHERE user_id = ? AND key = ?`, userID, k); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			`INSERT INTO user_profiles (user_id, key, value, updated_at) VALUES (?, ?, ?, ?)
+             ON CONFLICT(user_id, key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+			userID, k, string(raw), time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
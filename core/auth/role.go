@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/MickDuprez/gobase/core/middleware"
+)
+
+const (
+	// PermAdmin grants access to the admin area
+	PermAdmin = "admin"
+	// PermImpersonate allows logging in as other users
+	PermImpersonate = "impersonate"
+)
+
+var ErrUnknownRole = errors.New("unknown role")
+
+// Role is a named set of permissions that users can be given
+type Role struct {
+	Name        string
+	Description string
+	Permissions []string
+}
+
+// DefineRole creates a role or replaces its description and permissions,
+// features call it at startup for the roles they need
+func (a *AuthDB) DefineRole(name, description string, permissions ...string) error {
+	_, err := a.db.Exec(
+		`INSERT INTO roles (name, description, permissions) VALUES (?, ?, ?)
+         ON CONFLICT(name) DO UPDATE SET description = excluded.description, permissions = excluded.permissions`,
+		name, description, strings.Join(permissions, " "),
+	)
+	return err
+}
+
+func (a *AuthDB) ListRoles() ([]*Role, error) {
+	rows, err := a.db.Query(`SELECT name, description, permissions FROM roles ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*Role
+	for rows.Next() {
+		var role Role
+		var perms string
+		if err := rows.Scan(&role.Name, &role.Description, &perms); err != nil {
+			return nil, err
+		}
+		role.Permissions = strings.Fields(perms)
+		roles = append(roles, &role)
+	}
+	return roles, rows.Err()
+}
+
+// GetUserRoles returns the names of the user's roles
+func (a *AuthDB) GetUserRoles(userID int64) ([]string, error) {
+	rows, err := a.db.Query(`SELECT role FROM user_roles WHERE user_id = ? ORDER BY role`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func (a *AuthDB) AssignRole(userID int64, role string) error {
+	var exists int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM roles WHERE name = ?`, role).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return ErrUnknownRole
+	}
+
+	_, err := a.db.Exec(`INSERT OR IGNORE INTO user_roles (user_id, role) VALUES (?, ?)`, userID, role)
+	return err
+}
+
+func (a *AuthDB) RemoveRole(userID int64, role string) error {
+	_, err := a.db.Exec(`DELETE FROM user_roles WHERE user_id = ? AND role = ?`, userID, role)
+	return err
+}
+
+// UserPermissions returns every permission the user has through their roles
+func (a *AuthDB) UserPermissions(userID int64) ([]string, error) {
+	rows, err := a.db.Query(
+		`SELECT r.permissions FROM roles r JOIN user_roles ur ON ur.role = r.name WHERE ur.user_id = ?`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var perms string
+		if err := rows.Scan(&perms); err != nil {
+			return nil, err
+		}
+		for _, p := range strings.Fields(perms) {
+			seen[p] = true
+		}
+	}
+
+	perms := make([]string, 0, len(seen))
+	for p := range seen {
+		perms = append(perms, p)
+	}
+	sort.Strings(perms)
+	return perms, rows.Err()
+}
+
+// HasPermission reports whether any of the user's roles grants perm. Admins
+// have every permission.
+func (a *AuthDB) HasPermission(userID int64, perm string) (bool, error) {
+	perms, err := a.UserPermissions(userID)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range perms {
+		if p == perm || p == PermAdmin {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RequirePermission middleware only lets through users with perm, use it
+// inside RequireAuth
+func (a *AuthDB) RequirePermission(perm string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r)
+		if user == nil {
+			Unauthorized(w, r)
+			return
+		}
+
+		ok, err := a.HasPermission(user.ID, perm)
+		if err != nil {
+			WriteError(w, r, http.StatusInternalServerError, fmt.Errorf("failed to check permissions: %w", err))
+			return
+		}
+		if !ok {
+			if middleware.WantsJSON(r) {
+				writeJSONError(w, http.StatusForbidden, "forbidden")
+				return
+			}
+			WriteError(w, r, http.StatusForbidden, nil)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// bootstrapAdmins gives the admin role to the configured admin emails, once
+// their users have verified them. It's only done at startup, registering
+// with one of the addresses gets nothing.
+func (a *AuthDB) bootstrapAdmins() error {
+	for _, email := range a.cfg.AdminEmails {
+		user, err := a.GetUserByEmail(email)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			continue
+		}
+		if !user.EmailVerified() {
+			log.Printf("Not making %s an admin until they verify their email", user.Email)
+			continue
+		}
+		if err := a.AssignRole(user.ID, "admin"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBootstrapAdminsNeedsVerifiedEmail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.db")
+	cfg := testConfig()
+	cfg.AdminEmails = []string{"Admin@Example.com"}
+
+	open := func() *AuthDB {
+		t.Helper()
+		a, err := openAuthDB(path, cfg)
+		if err != nil {
+			t.Fatalf("openAuthDB: %v", err)
+		}
+		t.Cleanup(func() { a.Close() })
+		return a
+	}
+	isAdmin := func(a *AuthDB, user *User) bool {
+		t.Helper()
+		ok, err := a.HasPermission(user.ID, PermAdmin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	a := open()
+	user := createTestUser(t, a, "admin@example.com")
+	if isAdmin(a, user) {
+		t.Fatal("registering with an admin email made the user an admin")
+	}
+
+	// a restart doesn't help until the email is verified
+	a.Close()
+	a = open()
+	if isAdmin(a, user) {
+		t.Fatal("an unverified admin email was made an admin at startup")
+	}
+
+	token, err := a.VerifyEmail(user.ID)
+	if err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if _, err := a.ConfirmEmailChange(token); err != nil {
+		t.Fatalf("ConfirmEmailChange: %v", err)
+	}
+	a.Close()
+	a = open()
+	if !isAdmin(a, user) {
+		t.Error("a verified admin email wasn't made an admin at startup")
+	}
+}
+
+func TestAdminEmailCaseCantRegisterTwice(t *testing.T) {
+	a := newTestAuthDB(t, func(cfg *Config) {
+		cfg.AdminEmails = []string{"admin@example.com"}
+	})
+	createTestUser(t, a, "admin@example.com")
+
+	if _, err := a.CreateUser("ADMIN@example.com", testPassword, "Mallory"); err == nil {
+		t.Fatal("registered ADMIN@example.com alongside admin@example.com")
+	}
+}
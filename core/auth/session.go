@@ -2,9 +2,11 @@ package auth
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"net/http"
 	"time"
 )
 
@@ -14,6 +16,19 @@ type Session struct {
 	CreatedAt time.Time
 	ExpiresAt time.Time
 	Data      map[string]interface{}
+
+	// ImpersonatorID is the admin using this session to act as UserID, 0
+	// for normal sessions
+	ImpersonatorID int64
+	// originalSessionID is the admin's own session, restored when the
+	// impersonation ends
+	originalSessionID string
+}
+
+// Impersonated reports whether an admin is using the session to act as the
+// user
+func (s *Session) Impersonated() bool {
+	return s.ImpersonatorID != 0
 }
 
 // Helper methods for working with session data
@@ -80,31 +95,38 @@ func (a *AuthDB) CreateSession(userID int64, duration time.Duration) (*Session,
 		Data:      make(map[string]interface{}),
 	}
 
+	if err := a.insertSession(session); err != nil {
+		return nil, err
+	}
+	a.publish(UserLoggedIn{UserID: userID, SessionKey: session.Key()})
+	return session, nil
+}
+
+func (a *AuthDB) insertSession(session *Session) error {
 	data, err := json.Marshal(session.Data)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	_, err = a.db.Exec(
-		`INSERT INTO sessions (id, user_id, expires_at, data) VALUES (?, ?, ?, ?)`,
+		`INSERT INTO sessions (id, user_id, expires_at, data, impersonator_id, original_session_id)
+         VALUES (?, ?, ?, ?, ?, ?)`,
 		session.ID, session.UserID, session.ExpiresAt, string(data),
+		nullID(session.ImpersonatorID), sql.NullString{String: session.originalSessionID, Valid: session.originalSessionID != ""},
 	)
-	if err != nil {
-		return nil, err
-	}
-
-	return session, nil
+	return err
 }
 
-func (a *AuthDB) GetSession(id string) (*Session, error) {
+const sessionColumns = `id, user_id, created_at, expires_at, data, impersonator_id, original_session_id`
+
+func scanSession(row interface{ Scan(...any) error }) (*Session, error) {
 	var session Session
 	var dataStr string
+	var impersonatorID sql.NullInt64
+	var originalID sql.NullString
 
-	err := a.db.QueryRow(
-		`SELECT id, user_id, created_at, expires_at, data FROM sessions WHERE id = ?`,
-		id,
-	).Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.ExpiresAt, &dataStr)
-
+	err := row.Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.ExpiresAt, &dataStr,
+		&impersonatorID, &originalID)
 	if err != nil {
 		return nil, err
 	}
@@ -112,16 +134,160 @@ func (a *AuthDB) GetSession(id string) (*Session, error) {
 	if err := json.Unmarshal([]byte(dataStr), &session.Data); err != nil {
 		return nil, err
 	}
+	session.ImpersonatorID = impersonatorID.Int64
+	session.originalSessionID = originalID.String
+	return &session, nil
+}
+
+func (a *AuthDB) GetSession(id string) (*Session, error) {
+	session, err := scanSession(a.db.QueryRow(
+		`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`,
+		id,
+	))
+	if err != nil {
+		return nil, err
+	}
 
 	if time.Now().After(session.ExpiresAt) {
-		a.DeleteSession(id)
+		// expired rather than revoked, so no event
+		a.db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
 		return nil, errors.New("session expired")
 	}
 
-	return &session, nil
+	return session, nil
 }
 
 func (a *AuthDB) DeleteSession(id string) error {
-	_, err := a.db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
-	return err
+	return a.revokeSessions(`id = ?`, id)
+}
+
+// RevokeSessions logs the user out everywhere except the session exceptID,
+// pass an empty exceptID to revoke them all. Sessions they're using to
+// impersonate someone else end too.
+func (a *AuthDB) RevokeSessions(userID int64, exceptID string) error {
+	return a.revokeSessions(`(user_id = ? OR impersonator_id = ?) AND id != ?`, userID, userID, exceptID)
+}
+
+// DeleteExpiredSessions clears out sessions that have expired, returning
+// how many there were
+func (a *AuthDB) DeleteExpiredSessions() (int64, error) {
+	result, err := a.db.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// revokeSessions deletes the sessions matching where, publishing a
+// SessionRevoked for each
+func (a *AuthDB) revokeSessions(where string, args ...interface{}) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, user_id FROM sessions WHERE `+where, args...)
+	if err != nil {
+		return err
+	}
+	var revoked []SessionRevoked
+	for rows.Next() {
+		var id string
+		var userID int64
+		if err := rows.Scan(&id, &userID); err != nil {
+			rows.Close()
+			return err
+		}
+		revoked = append(revoked, SessionRevoked{UserID: userID, SessionKey: sessionKey(id)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE `+where, args...); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, event := range revoked {
+		a.publish(event)
+	}
+	return nil
+}
+
+// Key identifies the session without revealing its ID, which is as good as
+// a password, so it can be shown in pages and used to revoke the session
+func (s *Session) Key() string {
+	return sessionKey(s.ID)
+}
+
+func sessionKey(id string) string {
+	return hashToken(id)[:16]
+}
+
+// ListSessions returns the user's active sessions, newest first
+func (a *AuthDB) ListSessions(userID int64) ([]*Session, error) {
+	rows, err := a.db.Query(
+		`SELECT `+sessionColumns+` FROM sessions
+         WHERE user_id = ? AND expires_at > ? ORDER BY created_at DESC`,
+		userID, time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSessionByKey logs out the user's session with the given Key
+func (a *AuthDB) RevokeSessionByKey(userID int64, key string) error {
+	sessions, err := a.ListSessions(userID)
+	if err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		if s.Key() == key {
+			return a.DeleteSession(s.ID)
+		}
+	}
+	return errors.New("session not found")
+}
+
+// SetSessionCookie sends the session cookie for session to the browser
+func SetSessionCookie(w http.ResponseWriter, session *Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_id",
+		Value:    session.ID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(time.Until(session.ExpiresAt).Seconds()),
+	})
+}
+
+// ClearSessionCookie removes the session cookie from the browser
+func ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_id",
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1,
+	})
 }
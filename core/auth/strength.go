@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// PasswordStrength estimates how hard a password is to guess on the same 0-4
+// scale as zxcvbn: 0 is too guessable, 4 is very unguessable. Like zxcvbn it
+// looks for the patterns people actually use (common passwords and words,
+// keyboard runs, sequences, repeats and years) and takes the cheapest way to
+// guess the whole password, falling back to brute force for what's left.
+func PasswordStrength(password string) int {
+	guesses := estimateGuessesLog10(password)
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// estimateGuessesLog10 returns log10 of the estimated number of guesses
+func estimateGuessesLog10(password string) float64 {
+	runes := []rune(password)
+	n := len(runes)
+	if n == 0 {
+		return 0
+	}
+
+	bruteForce := math.Log10(float64(cardinality(runes)))
+
+	// best[j] is the cheapest way to guess the first j characters, either
+	// brute forcing one more character or completing a pattern
+	best := make([]float64, n+1)
+	for j := 1; j <= n; j++ {
+		best[j] = math.Inf(1)
+	}
+	for i := 0; i < n; i++ {
+		if c := best[i] + bruteForce; c < best[i+1] {
+			best[i+1] = c
+		}
+		for _, m := range matchesAt(runes, i) {
+			if c := best[i] + m.guesses; c < best[m.end] {
+				best[m.end] = c
+			}
+		}
+	}
+
+	return best[n]
+}
+
+type match struct {
+	end     int
+	guesses float64 // log10
+}
+
+func matchesAt(runes []rune, i int) []match {
+	var matches []match
+	n := len(runes)
+
+	// common passwords and words, case insensitive and with l33t undone.
+	// Nothing longer than the longest word can match, which keeps this from
+	// taking time with the square of the password's length.
+	for j := min(n, i+longestCommonWord); j >= i+3; j-- {
+		word := string(runes[i:j])
+		rank, ok := commonWords[unleet(strings.ToLower(word))]
+		if !ok {
+			continue
+		}
+		guesses := float64(rank)
+		if strings.ToLower(word) != word {
+			guesses *= 2
+		}
+		if unleet(strings.ToLower(word)) != strings.ToLower(word) {
+			guesses *= 2
+		}
+		matches = append(matches, match{end: j, guesses: math.Log10(guesses)})
+	}
+
+	// repeats like aaaa
+	j := i + 1
+	for j < n && runes[j] == runes[i] {
+		j++
+	}
+	if j-i >= 3 {
+		matches = append(matches, match{end: j, guesses: math.Log10(float64(cardinality(runes[i:i+1]) * (j - i)))})
+	}
+
+	// sequences like abcd or 9876
+	if i+1 < n {
+		delta := runes[i+1] - runes[i]
+		if delta == 1 || delta == -1 {
+			j := i + 1
+			for j < n && runes[j]-runes[j-1] == delta {
+				j++
+			}
+			if j-i >= 3 {
+				start := 26.0
+				if r := unicode.ToLower(runes[i]); r == 'a' || r == 'z' || r == '0' || r == '1' || r == '9' {
+					start = 4
+				}
+				guesses := start * float64(j-i)
+				if delta == -1 {
+					guesses *= 2
+				}
+				matches = append(matches, match{end: j, guesses: math.Log10(guesses)})
+			}
+		}
+	}
+
+	// keyboard runs like qwerty
+	for j := min(n, i+longestKeyboardRow); j >= i+4; j-- {
+		run := strings.ToLower(string(runes[i:j]))
+		if onKeyboardRow(run) {
+			matches = append(matches, match{end: j, guesses: math.Log10(float64(40 * (j - i)))})
+			break
+		}
+	}
+
+	// years
+	if i+4 <= n {
+		s := string(runes[i : i+4])
+		if (strings.HasPrefix(s, "19") || strings.HasPrefix(s, "20")) && isDigits(s) {
+			matches = append(matches, match{end: i + 4, guesses: math.Log10(200)})
+		}
+	}
+
+	return matches
+}
+
+// cardinality is the size of the character set the password draws from
+func cardinality(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	c := 0
+	if lower {
+		c += 26
+	}
+	if upper {
+		c += 26
+	}
+	if digit {
+		c += 10
+	}
+	if symbol {
+		c += 33
+	}
+	if other {
+		c += 100
+	}
+	return c
+}
+
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./", "1qaz2wsx3edc4rfv5tgb6yhn7ujm8ik9ol0p"}
+
+var longestKeyboardRow = longest(keyboardRows)
+
+// longest is the length in runes of the longest of words
+func longest(words []string) int {
+	n := 0
+	for _, w := range words {
+		n = max(n, len([]rune(w)))
+	}
+	return n
+}
+
+func onKeyboardRow(s string) bool {
+	for _, row := range keyboardRows {
+		if strings.Contains(row, s) || strings.Contains(reverse(row), s) {
+			return true
+		}
+	}
+	return false
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+var leetReplacer = strings.NewReplacer("4", "a", "@", "a", "8", "b", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t", "+", "t")
+
+func unleet(s string) string {
+	return leetReplacer.Replace(s)
+}
+
+// commonWords maps the most common passwords and password words to their
+// rank, lower is more common
+var commonWords = func() map[string]int {
+	words := strings.Fields(`
+		password 123456 12345678 qwerty abc123 monkey letmein dragon 111111
+		baseball iloveyou trustno1 sunshine master welcome shadow ashley
+		football jesus michael ninja mustang password1 admin login princess
+		solo starwars whatever qazwsx freedom batman access hello charlie
+		donald secret superman hunter ranger buster soccer harley hockey
+		killer george andrew thomas jordan michelle pepper daniel summer
+		winter spring autumn flower computer internet cheese silver golden
+		orange purple yellow cookie banana chocolate maggie ginger tigger
+		jennifer joshua matthew robert jessica pass test guest root user
+		changeme default love lovely angel angels baby babygirl family
+		friends forever football1 money monkey1 nicole samsung apple google
+		facebook twitter linkedin company office work london paris berlin
+		america canada australia england dallas chicago boston texas
+		happy smile lucky blessed heaven mother father sister brother
+		pokemon naruto matrix liverpool chelsea arsenal yankees lakers
+		cowboys eagles tiger lion bear wolf dog cat fish horse rabbit
+		red blue green black white pink gold star moon sun sky fire water
+		welcome1 qwerty123 passw0rd p@ssword admin123 root123 test123
+	`)
+	m := make(map[string]int, len(words))
+	for i, w := range words {
+		// keys are stored the way they are looked up
+		w = unleet(w)
+		if _, ok := m[w]; !ok {
+			m[w] = i + 1
+		}
+	}
+	return m
+}()
+
+var longestCommonWord = func() int {
+	words := make([]string, 0, len(commonWords))
+	for w := range commonWords {
+		words = append(words, w)
+	}
+	return longest(words)
+}()
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// tokenPrefix marks our personal access tokens so they are easy to spot in
+// logs and secret scanners
+const tokenPrefix = "gb_"
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// APIToken is a personal access token. Only a hash of the secret is stored,
+// the secret itself is shown to the user once when the token is created.
+type APIToken struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	Prefix     string
+	Scopes     []string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// HasScope reports whether the token was granted scope. A token without any
+// scopes has full access.
+func (t *APIToken) HasScope(scope string) bool {
+	if len(t.Scopes) == 0 {
+		return true
+	}
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+func generateTokenSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken creates a new token for the user and returns it along with the
+// secret. The secret can't be recovered later. A zero expiresIn never expires.
+func (a *AuthDB) CreateToken(userID int64, name string, scopes []string, expiresIn time.Duration) (*APIToken, string, error) {
+	secret, err := generateTokenSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := &APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    secret[:len(tokenPrefix)+6],
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+
+	var expiresAt sql.NullTime
+	if expiresIn > 0 {
+		t := time.Now().Add(expiresIn)
+		token.ExpiresAt = &t
+		expiresAt = sql.NullTime{Time: t, Valid: true}
+	}
+
+	result, err := a.db.Exec(
+		`INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, name, hashToken(secret), token.Prefix, strings.Join(scopes, " "), expiresAt,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token.ID, err = result.LastInsertId()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return token, secret, nil
+}
+
+func scanToken(row interface{ Scan(...any) error }) (*APIToken, error) {
+	var token APIToken
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.Prefix, &scopes,
+		&expiresAt, &lastUsedAt, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	return &token, nil
+}
+
+const tokenColumns = `id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at`
+
+// ListTokens returns the user's tokens, newest first
+func (a *AuthDB) ListTokens(userID int64) ([]*APIToken, error) {
+	rows, err := a.db.Query(
+		`SELECT `+tokenColumns+` FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC, id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*APIToken
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeToken deletes one of the user's tokens
+func (a *AuthDB) RevokeToken(userID, tokenID int64) error {
+	result, err := a.db.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// ValidateToken looks up the token for a secret, checks it hasn't expired and
+// records that it was used.
+func (a *AuthDB) ValidateToken(secret string) (*User, *APIToken, error) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	token, err := scanToken(a.db.QueryRow(
+		`SELECT `+tokenColumns+` FROM api_tokens WHERE token_hash = ?`,
+		hashToken(secret),
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if token.Expired() {
+		return nil, nil, ErrTokenExpired
+	}
+
+	user, err := a.GetUserByID(token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, ErrInvalidToken
+	}
+	if user.Disabled() {
+		return nil, nil, ErrUserDisabled
+	}
+
+	now := time.Now()
+	if _, err := a.db.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, now, token.ID); err != nil {
+		return nil, nil, err
+	}
+	token.LastUsedAt = &now
+
+	return user, token, nil
+}
@@ -3,9 +3,11 @@ package auth
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
@@ -14,17 +16,135 @@ type User struct {
 	PasswordHash string
 	Name         string
 	CreatedAt    time.Time
+	DisabledAt   *time.Time
+	// EmailVerifiedAt is when the user showed they own the email address,
+	// nil if they haven't
+	EmailVerifiedAt *time.Time
+	// MustChangePassword is set when an admin forces a password reset
+	MustChangePassword bool
+}
+
+var ErrUserDisabled = errors.New("user is disabled")
+
+// Disabled reports whether the account has been deactivated
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
+// EmailVerified reports whether the user has shown they own their email
+// address, by confirming it, using a login link or invitation sent to it,
+// or logging in through a provider that verified it
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// HasPassword reports whether the user can log in with a password, users
+// made by CreateUserWithoutPassword can't until they set one
+func (u *User) HasPassword() bool {
+	return u.PasswordHash != ""
+}
+
+const userColumns = `id, email, password_hash, name, created_at, disabled_at, email_verified_at, password_reset_required`
+
+func scanUser(row interface{ Scan(...any) error }) (*User, error) {
+	var user User
+	var disabledAt, verifiedAt sql.NullTime
+
+	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.CreatedAt, &disabledAt,
+		&verifiedAt, &user.MustChangePassword)
+	if err != nil {
+		return nil, err
+	}
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
+	if verifiedAt.Valid {
+		user.EmailVerifiedAt = &verifiedAt.Time
+	}
+	return &user, nil
 }
 
+// normalizeEmail is the form emails are stored and looked up in, so
+// addresses differing only in case belong to one account
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// CreateUser validates and stores a new user. Invalid input is reported as
+// ValidationErrors so forms can show each problem next to its field. The
+// registration mode is enforced here so no feature can get around it, in
+// invite mode use CreateUserWithInvitation.
 func (a *AuthDB) CreateUser(email, password, name string) (*User, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	switch a.cfg.RegistrationMode {
+	case RegistrationOpen, RegistrationDomains:
+	case RegistrationInvite:
+		return nil, ErrInvitationRequired
+	default:
+		// unknown modes are treated as closed rather than open
+		return nil, ErrRegistrationClosed
+	}
+	return a.createUser(email, name, &password, nil)
+}
+
+// CreateUserWithoutPassword is CreateUser for someone who logs in some other
+// way, through a login provider or with login links. They have no password
+// to log in with, or to be asked for, until they set one.
+func (a *AuthDB) CreateUserWithoutPassword(email, name string) (*User, error) {
+	switch a.cfg.RegistrationMode {
+	case RegistrationOpen, RegistrationDomains:
+	case RegistrationInvite:
+		return nil, ErrInvitationRequired
+	default:
+		return nil, ErrRegistrationClosed
+	}
+	return a.createUser(email, name, nil, nil)
+}
+
+// createUser stores a new user, using up the invitation if there is one.
+// password is nil for a user without one.
+func (a *AuthDB) createUser(email, name string, password *string, invitation *Invitation) (*User, error) {
+	email = normalizeEmail(email)
+	name = strings.TrimSpace(name)
+
+	errs := a.validateNewUser(email, name)
+	if password != nil {
+		errs = append(errs, a.cfg.PasswordPolicy.Validate(*password, email, name)...)
+	}
+	if invitation != nil {
+		if !strings.EqualFold(invitation.Email, email) {
+			errs.Add("email", "This invitation is for a different email address")
+		}
+	} else if a.cfg.RegistrationMode == RegistrationDomains && !a.domainAllowed(email) {
+		errs.Add("email", "Registration is limited to "+strings.Join(a.cfg.AllowedDomains, ", ")+" addresses")
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	var hash string
+	if password != nil {
+		var err error
+		if hash, err = a.cfg.Hasher.Hash(*password); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := a.db.Begin()
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
+
+	// the invitation was sent to the email, so using it verifies it
+	var verifiedAt *time.Time
+	if invitation != nil {
+		now := time.Now()
+		verifiedAt = &now
+	}
 
-	result, err := a.db.Exec(
-		`INSERT INTO users (email, password_hash, name) VALUES (?, ?, ?)`,
-		email, string(hash), name,
+	result, err := tx.Exec(
+		`INSERT INTO users (email, password_hash, name, email_verified_at) VALUES (?, ?, ?, ?)`,
+		email, hash, name, verifiedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -35,21 +155,55 @@ func (a *AuthDB) CreateUser(email, password, name string) (*User, error) {
 		return nil, err
 	}
 
-	return &User{
-		ID:           id,
-		Email:        email,
-		PasswordHash: string(hash),
-		Name:         name,
-		CreatedAt:    time.Now(),
-	}, nil
+	if invitation != nil {
+		// single use, whoever gets here first wins
+		result, err := tx.Exec(
+			`UPDATE invitations SET used_at = ?, used_by = ? WHERE id = ? AND used_at IS NULL AND expires_at > ?`,
+			time.Now(), id, invitation.ID, time.Now(),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil, ErrInvalidInvitation
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		ID:              id,
+		Email:           email,
+		PasswordHash:    hash,
+		Name:            name,
+		CreatedAt:       time.Now(),
+		EmailVerifiedAt: verifiedAt,
+	}
+	a.publish(UserCreated{User: user})
+	return user, nil
+}
+
+func (a *AuthDB) domainAllowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, d := range a.cfg.AllowedDomains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
 }
 
 func (a *AuthDB) GetUserByEmail(email string) (*User, error) {
-	var user User
-	err := a.db.QueryRow(
-		`SELECT id, email, password_hash, name, created_at FROM users WHERE email = ?`,
-		email,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.CreatedAt)
+	user, err := scanUser(a.db.QueryRow(
+		`SELECT `+userColumns+` FROM users WHERE email = ?`,
+		normalizeEmail(email),
+	))
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -57,7 +211,7 @@ func (a *AuthDB) GetUserByEmail(email string) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &user, nil
+	return user, nil
 }
 
 func (a *AuthDB) ValidateUser(email, password string) (*User, error) {
@@ -69,20 +223,28 @@ func (a *AuthDB) ValidateUser(email, password string) (*User, error) {
 		return nil, errors.New("user not found")
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
-	if err != nil {
+	ok, err := a.verifyPassword(user.PasswordHash, password)
+	if err != nil || !ok {
 		return nil, errors.New("invalid password")
 	}
+	if user.Disabled() {
+		return nil, ErrUserDisabled
+	}
+
+	// Upgrade the stored hash if the hashing policy changed, a failure here
+	// shouldn't stop the user logging in
+	if err := a.rehashPassword(user, password); err != nil {
+		log.Printf("Failed to rehash password for user %d: %v", user.ID, err)
+	}
 
 	return user, nil
 }
 
 func (a *AuthDB) GetUserByID(id int64) (*User, error) {
-	var user User
-	err := a.db.QueryRow(
-		`SELECT id, email, password_hash, name, created_at FROM users WHERE id = ?`,
+	user, err := scanUser(a.db.QueryRow(
+		`SELECT `+userColumns+` FROM users WHERE id = ?`,
 		id,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.CreatedAt)
+	))
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -90,5 +252,304 @@ func (a *AuthDB) GetUserByID(id int64) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &user, nil
+	return user, nil
+}
+
+func (a *AuthDB) validateNewUser(email, name string) ValidationErrors {
+	var errs ValidationErrors
+
+	if name == "" {
+		errs.Add("name", "Name is required")
+	}
+
+	errs = append(errs, a.validateEmail(email)...)
+
+	return errs
+}
+
+// validateEmail checks email is well formed and not used by anyone else
+func (a *AuthDB) validateEmail(email string) ValidationErrors {
+	var errs ValidationErrors
+
+	if email == "" {
+		errs.Add("email", "Email is required")
+	} else if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		errs.Add("email", "Email address is not valid")
+	} else if existing, err := a.GetUserByEmail(email); err == nil && existing != nil {
+		errs.Add("email", "An account with this email already exists")
+	}
+
+	return errs
+}
+
+// emailChangeTTL is how long a new email address has to be confirmed
+const emailChangeTTL = 24 * time.Hour
+
+// UpdateUser saves changes to the user's details. The email and password
+// have their own methods as they need verifying.
+func (a *AuthDB) UpdateUser(user *User) error {
+	user.Name = strings.TrimSpace(user.Name)
+
+	var errs ValidationErrors
+	if user.Name == "" {
+		errs.Add("name", "Name is required")
+		return errs
+	}
+
+	_, err := a.db.Exec(`UPDATE users SET name = ? WHERE id = ?`, user.Name, user.ID)
+	return err
+}
+
+// ChangePassword sets a new password after checking the current one, then
+// logs the user out everywhere except the session keepSessionID. A user
+// without a password has no current one to check.
+func (a *AuthDB) ChangePassword(userID int64, current, password, keepSessionID string) error {
+	user, err := a.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+
+	var errs ValidationErrors
+	if user.HasPassword() {
+		if ok, err := a.verifyPassword(user.PasswordHash, current); err != nil || !ok {
+			errs.Add("current_password", "Current password is incorrect")
+			return errs
+		}
+	}
+	if errs = a.cfg.PasswordPolicy.Validate(password, user.Email, user.Name); len(errs) > 0 {
+		return errs
+	}
+
+	hash, err := a.cfg.Hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.db.Exec(`UPDATE users SET password_hash = ?, password_reset_required = 0 WHERE id = ?`, hash, userID)
+	if err != nil {
+		return err
+	}
+
+	return a.RevokeSessions(userID, keepSessionID)
+}
+
+// ChangeEmail starts changing the user's email. Nothing changes until the
+// returned token, which should be sent to the new address, is confirmed with
+// ConfirmEmailChange.
+func (a *AuthDB) ChangeEmail(userID int64, newEmail string) (string, error) {
+	newEmail = normalizeEmail(newEmail)
+	if errs := a.validateEmail(newEmail); len(errs) > 0 {
+		return "", errs
+	}
+	return a.startEmailChange(userID, newEmail)
+}
+
+// VerifyEmail starts verifying the user's current email. The returned
+// token, which should be sent to the address, is confirmed with
+// ConfirmEmailChange like a change of email.
+func (a *AuthDB) VerifyEmail(userID int64) (string, error) {
+	user, err := a.GetUserByID(userID)
+	if err != nil {
+		return "", err
+	}
+	if user == nil {
+		return "", errors.New("user not found")
+	}
+	return a.startEmailChange(userID, user.Email)
+}
+
+func (a *AuthDB) startEmailChange(userID int64, newEmail string) (string, error) {
+	token, err := generateSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	// only the latest request can be confirmed
+	if _, err := a.db.Exec(`DELETE FROM email_changes WHERE user_id = ?`, userID); err != nil {
+		return "", err
+	}
+
+	_, err = a.db.Exec(
+		`INSERT INTO email_changes (user_id, new_email, token_hash, expires_at) VALUES (?, ?, ?, ?)`,
+		userID, newEmail, hashToken(token), time.Now().Add(emailChangeTTL),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConfirmEmailChange switches the user to the email the token was sent to,
+// which verifies it
+func (a *AuthDB) ConfirmEmailChange(token string) (*User, error) {
+	var id, userID int64
+	var newEmail string
+	var expiresAt time.Time
+
+	err := a.db.QueryRow(
+		`SELECT id, user_id, new_email, expires_at FROM email_changes WHERE token_hash = ?`,
+		hashToken(token),
+	).Scan(&id, &userID, &newEmail, &expiresAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidLink
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := a.db.Exec(`DELETE FROM email_changes WHERE id = ?`, id); err != nil {
+		return nil, err
+	}
+	if time.Now().After(expiresAt) {
+		return nil, ErrInvalidLink
+	}
+
+	// someone may have taken the address since the change was requested,
+	// unless it's the user's own being verified
+	if owner, err := a.GetUserByEmail(newEmail); err != nil {
+		return nil, err
+	} else if owner == nil || owner.ID != userID {
+		if errs := a.validateEmail(newEmail); len(errs) > 0 {
+			return nil, errs
+		}
+	}
+
+	_, err = a.db.Exec(`UPDATE users SET email = ?, email_verified_at = ? WHERE id = ?`, newEmail, time.Now(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.GetUserByID(userID)
+}
+
+// MarkEmailVerified records that the user has shown they own their email,
+// say because a provider they logged in with verified it
+func (a *AuthDB) MarkEmailVerified(userID int64) error {
+	_, err := a.db.Exec(
+		`UPDATE users SET email_verified_at = ? WHERE id = ? AND email_verified_at IS NULL`,
+		time.Now(), userID,
+	)
+	return err
+}
+
+// DisableUser deactivates the account and logs it out everywhere, the user
+// is kept so it can be enabled again
+func (a *AuthDB) DisableUser(userID int64) error {
+	if _, err := a.db.Exec(`UPDATE users SET disabled_at = ? WHERE id = ?`, time.Now(), userID); err != nil {
+		return err
+	}
+	return a.RevokeSessions(userID, "")
+}
+
+func (a *AuthDB) EnableUser(userID int64) error {
+	_, err := a.db.Exec(`UPDATE users SET disabled_at = NULL WHERE id = ?`, userID)
+	return err
+}
+
+// ForcePasswordReset logs the user out everywhere and makes them choose a new
+// password the next time they log in
+func (a *AuthDB) ForcePasswordReset(userID int64) error {
+	if _, err := a.db.Exec(`UPDATE users SET password_reset_required = 1 WHERE id = ?`, userID); err != nil {
+		return err
+	}
+	return a.RevokeSessions(userID, "")
+}
+
+// UserQuery filters and pages ListUsers
+type UserQuery struct {
+	Search string // matches name or email
+	Offset int
+	Limit  int
+}
+
+// ListUsers returns a page of users ordered by id along with the total
+// number of users matching the query
+func (a *AuthDB) ListUsers(q UserQuery) ([]*User, int, error) {
+	where := ""
+	var args []interface{}
+	if s := strings.TrimSpace(q.Search); s != "" {
+		where = ` WHERE name LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\'`
+		like := "%" + likeEscaper.Replace(s) + "%"
+		args = append(args, like, like)
+	}
+
+	var total int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM users`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = 20
+	}
+	rows, err := a.db.Query(
+		fmt.Sprintf(`SELECT %s FROM users%s ORDER BY id LIMIT ? OFFSET ?`, userColumns, where),
+		append(args, q.Limit, q.Offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+	return users, total, rows.Err()
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// DeleteUser removes the user along with everything that belongs to them
+func (a *AuthDB) DeleteUser(userID int64) error {
+	user, err := a.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	// logged out first so each session's revocation is published, as it is
+	// for any other way of being logged out
+	if err := a.RevokeSessions(userID, ""); err != nil {
+		return err
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []struct {
+		query string
+		arg   interface{}
+	}{
+		{`DELETE FROM sessions WHERE user_id = ?`, userID},
+		{`DELETE FROM sessions WHERE impersonator_id = ?`, userID},
+		{`DELETE FROM api_tokens WHERE user_id = ?`, userID},
+		{`DELETE FROM user_identities WHERE user_id = ?`, userID},
+		{`DELETE FROM email_changes WHERE user_id = ?`, userID},
+		{`DELETE FROM user_profiles WHERE user_id = ?`, userID},
+		{`DELETE FROM user_roles WHERE user_id = ?`, userID},
+		{`DELETE FROM login_links WHERE email = ?`, user.Email},
+		{`DELETE FROM users WHERE id = ?`, userID},
+	}
+
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt.query, stmt.arg); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
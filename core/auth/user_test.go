@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MickDuprez/gobase/core/events"
+)
+
+func TestDeleteUserPublishesSessionRevoked(t *testing.T) {
+	a := newTestAuthDB(t)
+	bus := events.New()
+	a.SetEvents(bus)
+
+	var revoked []SessionRevoked
+	events.Subscribe(bus, func(ctx context.Context, e SessionRevoked) error {
+		revoked = append(revoked, e)
+		return nil
+	})
+
+	user := createTestUser(t, a, "ann@example.com")
+	var keys []string
+	for i := 0; i < 2; i++ {
+		session, err := a.CreateSession(user.ID, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, session.Key())
+	}
+
+	if err := a.DeleteUser(user.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if len(revoked) != len(keys) {
+		t.Fatalf("got %d SessionRevoked events, want %d", len(revoked), len(keys))
+	}
+	for _, e := range revoked {
+		if e.UserID != user.ID || (e.SessionKey != keys[0] && e.SessionKey != keys[1]) {
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+	if deleted, _ := a.GetUserByID(user.ID); deleted != nil {
+		t.Error("user still exists")
+	}
+}
+
+func TestCreateUserWithoutPassword(t *testing.T) {
+	a := newTestAuthDB(t)
+
+	user, err := a.CreateUserWithoutPassword("ann@example.com", "Ann")
+	if err != nil {
+		t.Fatalf("CreateUserWithoutPassword: %v", err)
+	}
+	if user.HasPassword() {
+		t.Error("HasPassword is true for a user without one")
+	}
+	for _, password := range []string{"", testPassword} {
+		if _, err := a.ValidateUser(user.Email, password); err == nil {
+			t.Errorf("ValidateUser(%q) logged in a user without a password", password)
+		}
+	}
+
+	// there's no current password to give when setting the first one
+	if err := a.ChangePassword(user.ID, "", testPassword, ""); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	user, err = a.ValidateUser(user.Email, testPassword)
+	if err != nil {
+		t.Fatalf("ValidateUser after setting a password: %v", err)
+	}
+	if !user.HasPassword() {
+		t.Error("HasPassword is false after setting one")
+	}
+	if err := a.ChangePassword(user.ID, "", "another good passphrase", ""); err == nil {
+		t.Error("ChangePassword without the current password succeeded once there is one")
+	}
+}
+
+func TestEmailsAreCaseInsensitive(t *testing.T) {
+	a := newTestAuthDB(t)
+
+	user, err := a.CreateUser("  Ann@Example.COM ", testPassword, "Ann")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if user.Email != "ann@example.com" {
+		t.Errorf("email stored as %q, want it in lower case", user.Email)
+	}
+
+	for _, email := range []string{"ann@example.com", "ANN@EXAMPLE.COM"} {
+		found, err := a.GetUserByEmail(email)
+		if err != nil || found == nil || found.ID != user.ID {
+			t.Errorf("GetUserByEmail(%q) = %v, %v, want user %d", email, found, err, user.ID)
+		}
+		if _, err := a.ValidateUser(email, testPassword); err != nil {
+			t.Errorf("ValidateUser(%q): %v", email, err)
+		}
+	}
+
+	other := createTestUser(t, a, "bob@example.com")
+	if _, err := a.ChangeEmail(other.ID, "ANN@example.com"); err == nil {
+		t.Error("ChangeEmail to another user's address in a different case succeeded")
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	a := newTestAuthDB(t, func(cfg *Config) {
+		cfg.Passwordless = true
+	})
+
+	user := createTestUser(t, a, "ann@example.com")
+	if user.EmailVerified() {
+		t.Fatal("a new user's email is verified")
+	}
+
+	// a login link is sent to the email, so using one verifies it
+	_, token, err := a.CreateLoginLink(user.Email)
+	if err != nil {
+		t.Fatalf("CreateLoginLink: %v", err)
+	}
+	if user, err = a.ConsumeLoginLink(token); err != nil {
+		t.Fatalf("ConsumeLoginLink: %v", err)
+	}
+	if user, _ = a.GetUserByID(user.ID); !user.EmailVerified() {
+		t.Error("using a login link didn't verify the email")
+	}
+
+	// and so does confirming a new one
+	token, err = a.ChangeEmail(user.ID, "ann@example.org")
+	if err != nil {
+		t.Fatalf("ChangeEmail: %v", err)
+	}
+	verifiedAt := *user.EmailVerifiedAt
+	if user, err = a.ConfirmEmailChange(token); err != nil {
+		t.Fatalf("ConfirmEmailChange: %v", err)
+	}
+	if user.Email != "ann@example.org" || !user.EmailVerified() || !user.EmailVerifiedAt.After(verifiedAt) {
+		t.Errorf("after confirming the change email is %s verified at %v", user.Email, user.EmailVerifiedAt)
+	}
+}
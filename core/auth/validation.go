@@ -0,0 +1,39 @@
+package auth
+
+import "strings"
+
+// FieldError is a problem with one input field
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationErrors is returned when user input is rejected, templates can
+// show each message next to its field with {{.Get "password"}}
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Get returns the first message for field, empty if the field is fine
+func (v ValidationErrors) Get(field string) string {
+	for _, e := range v {
+		if e.Field == field {
+			return e.Message
+		}
+	}
+	return ""
+}
+
+func (v ValidationErrors) Has(field string) bool {
+	return v.Get(field) != ""
+}
+
+func (v *ValidationErrors) Add(field, message string) {
+	*v = append(*v, FieldError{Field: field, Message: message})
+}
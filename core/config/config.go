@@ -1,15 +1,22 @@
 package config
 
 import (
+	"github.com/MickDuprez/gobase/core/auth"
 	"github.com/MickDuprez/gobase/core/database"
+	"github.com/MickDuprez/gobase/core/jobs"
+	"github.com/MickDuprez/gobase/core/mail"
 	"github.com/MickDuprez/gobase/core/middleware"
 	"github.com/MickDuprez/gobase/core/utils"
 )
 
 type AppConfig struct {
-	Server    *ServerConfig
-	DBConfig  *database.Config
-	SecConfig *middleware.SecurityConfig
+	Server     *ServerConfig
+	DBConfig   *database.Config
+	SecConfig  *middleware.SecurityConfig
+	AuthConfig *auth.Config
+	MailConfig *mail.Config
+	JobsConfig *jobs.Config
+	Features   *FeaturesConfig
 }
 
 func NewAppConfig() *AppConfig {
@@ -17,15 +24,23 @@ func NewAppConfig() *AppConfig {
 
 	if isDev {
 		return &AppConfig{
-			Server:    NewServerConfig(),
-			DBConfig:  database.NewDBConfig(),
-			SecConfig: middleware.NewDevSecurityConfig(),
+			Server:     NewServerConfig(),
+			DBConfig:   database.NewDBConfig(),
+			SecConfig:  middleware.NewDevSecurityConfig(),
+			AuthConfig: auth.NewConfig(),
+			MailConfig: mail.NewMailConfig(),
+			JobsConfig: jobs.NewConfig(),
+			Features:   NewFeaturesConfig(),
 		}
 	}
 
 	return &AppConfig{
-		Server:    NewServerConfig(),
-		DBConfig:  database.NewDBConfig(),
-		SecConfig: middleware.NewProdSecurityConfig(),
+		Server:     NewServerConfig(),
+		DBConfig:   database.NewDBConfig(),
+		SecConfig:  middleware.NewProdSecurityConfig(),
+		AuthConfig: auth.NewConfig(),
+		MailConfig: mail.NewMailConfig(),
+		JobsConfig: jobs.NewConfig(),
+		Features:   NewFeaturesConfig(),
 	}
 }
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/MickDuprez/gobase/core/utils"
+)
+
+// FeaturesConfig says which features are enabled and holds their settings,
+// so one binary can serve deployments with different feature sets
+type FeaturesConfig struct {
+	// File is a JSON file with the enabled features and their settings:
+	//
+	//	{
+	//	  "enabled": ["home", "about", "users"],
+	//	  "settings": {"about": {"team": [{"name": "Ann", "role": "CEO"}]}}
+	//	}
+	File string
+	// Enabled are the features to set up, all of them when it's empty
+	Enabled []string
+	// Disabled are never set up, even when they're in Enabled
+	Disabled []string
+	// Settings are each feature's settings block, decoded into its Settings
+	Settings map[string]json.RawMessage
+}
+
+func NewFeaturesConfig() *FeaturesConfig {
+	return &FeaturesConfig{
+		File:     utils.GetEnvStr("FEATURES_FILE", "features.json"),
+		Enabled:  splitList(utils.GetEnvStr("FEATURES_ENABLED", "")),
+		Disabled: splitList(utils.GetEnvStr("FEATURES_DISABLED", "")),
+		Settings: make(map[string]json.RawMessage),
+	}
+}
+
+// Load reads File when it exists, FEATURES_ENABLED in the environment
+// takes precedence over its list
+func (c *FeaturesConfig) Load() error {
+	data, err := os.ReadFile(c.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read features file: %w", err)
+	}
+
+	var file struct {
+		Enabled  []string                   `json:"enabled"`
+		Disabled []string                   `json:"disabled"`
+		Settings map[string]json.RawMessage `json:"settings"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse features file %s: %w", c.File, err)
+	}
+
+	if len(c.Enabled) == 0 {
+		c.Enabled = file.Enabled
+	}
+	c.Disabled = append(c.Disabled, file.Disabled...)
+	for name, settings := range file.Settings {
+		c.Settings[name] = settings
+	}
+	return nil
+}
+
+// IsEnabled reports whether the feature should be set up
+func (c *FeaturesConfig) IsEnabled(name string) bool {
+	if contains(c.Disabled, name) {
+		return false
+	}
+	return len(c.Enabled) == 0 || contains(c.Enabled, name)
+}
+
+// splitList splits a comma or space separated setting
+func splitList(s string) []string {
+	return strings.Fields(strings.ReplaceAll(s, ",", " "))
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
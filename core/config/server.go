@@ -1,16 +1,38 @@
 package config
 
-import "github.com/MickDuprez/gobase/core/utils"
+import (
+	"time"
+
+	"github.com/MickDuprez/gobase/core/utils"
+)
 
 type ServerConfig struct {
 	Port string
+	// IsDev shows developer details, such as stack traces, in error pages
+	IsDev bool
+
+	// Timeouts for the http.Server, so slow or idle clients can't hold
+	// connections open forever
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout is how long stopping the server can take, requests in
+	// flight, scheduled tasks, jobs and OnShutdown hooks all share it
+	ShutdownTimeout time.Duration
 }
 
 func NewServerConfig() *ServerConfig {
 	isDev := utils.GetEnvBool("IS_DEV", true)
 
 	cfg := &ServerConfig{
-		Port: utils.GetEnvStr("PORT", ":3000"),
+		Port:              utils.GetEnvStr("PORT", ":3000"),
+		IsDev:             isDev,
+		ReadHeaderTimeout: time.Duration(utils.GetEnvInt("SERVER_READ_HEADER_TIMEOUT_SECONDS", 5)) * time.Second,
+		ReadTimeout:       time.Duration(utils.GetEnvInt("SERVER_READ_TIMEOUT_SECONDS", 15)) * time.Second,
+		WriteTimeout:      time.Duration(utils.GetEnvInt("SERVER_WRITE_TIMEOUT_SECONDS", 30)) * time.Second,
+		IdleTimeout:       time.Duration(utils.GetEnvInt("SERVER_IDLE_TIMEOUT_SECONDS", 120)) * time.Second,
+		ShutdownTimeout:   time.Duration(utils.GetEnvInt("SERVER_SHUTDOWN_TIMEOUT_SECONDS", 30)) * time.Second,
 	}
 
 	if !isDev {
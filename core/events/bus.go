@@ -0,0 +1,137 @@
+// Package events is an in-process publish/subscribe bus, so features can
+// react to each other, such as sending a welcome email when a user
+// registers, without importing each other
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Bus delivers events to the subscribers of their type. A subscriber that
+// fails or panics is logged and doesn't stop the others, or the code that
+// published the event.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[reflect.Type][]subscriber
+	closed      bool
+	pending     sync.WaitGroup // async deliveries in flight
+}
+
+type subscriber struct {
+	name   string // the handler's function, for logs
+	async  bool
+	handle func(ctx context.Context, event interface{}) error
+}
+
+func New() *Bus {
+	return &Bus{subscribers: make(map[reflect.Type][]subscriber)}
+}
+
+// Subscribe calls fn for every event of type E, before Publish returns.
+// Events are matched on their exact type, so subscribe to the type that's
+// published, e.g. auth.UserCreated rather than *auth.UserCreated.
+func Subscribe[E any](b *Bus, fn func(ctx context.Context, event E) error) {
+	b.subscribe(reflect.TypeFor[E](), newSubscriber(fn, false))
+}
+
+// SubscribeAsync calls fn for every event of type E in its own goroutine,
+// for slow work like sending email. Its ctx isn't cancelled when the
+// publisher's is, Close waits for it instead.
+func SubscribeAsync[E any](b *Bus, fn func(ctx context.Context, event E) error) {
+	b.subscribe(reflect.TypeFor[E](), newSubscriber(fn, true))
+}
+
+func newSubscriber[E any](fn func(ctx context.Context, event E) error, async bool) subscriber {
+	return subscriber{
+		name:  runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name(),
+		async: async,
+		handle: func(ctx context.Context, event interface{}) error {
+			return fn(ctx, event.(E))
+		},
+	}
+}
+
+func (b *Bus) subscribe(eventType reflect.Type, s subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[eventType] = append(b.subscribers[eventType], s)
+}
+
+// Publish delivers event to its type's subscribers, the synchronous ones in
+// the order they subscribed
+func (b *Bus) Publish(ctx context.Context, event interface{}) {
+	if b == nil || event == nil {
+		return
+	}
+
+	b.mu.RLock()
+	subs := b.subscribers[reflect.TypeOf(event)]
+	closed := b.closed
+	if !closed {
+		// counted under the lock so Close can't miss it
+		for _, s := range subs {
+			if s.async {
+				b.pending.Add(1)
+			}
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, s := range subs {
+		if !s.async {
+			deliver(ctx, s, event)
+			continue
+		}
+		if closed {
+			// nothing waits for goroutines any more, deliver it now
+			// rather than lose it
+			deliver(context.WithoutCancel(ctx), s, event)
+			continue
+		}
+		go func(s subscriber) {
+			defer b.pending.Done()
+			deliver(context.WithoutCancel(ctx), s, event)
+		}(s)
+	}
+}
+
+// deliver calls the subscriber, logging what goes wrong rather than
+// passing it on
+func deliver(ctx context.Context, s subscriber, event interface{}) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("panic in %s handling %T: %v\n%s", s.name, event, v, debug.Stack())
+		}
+	}()
+
+	if err := s.handle(ctx, event); err != nil {
+		log.Printf("Error in %s handling %T: %v", s.name, event, err)
+	}
+}
+
+// Close waits for async subscribers still running, until ctx is done.
+// Events published after it are delivered to them synchronously.
+func (b *Bus) Close(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for event subscribers: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,115 @@
+// Package admin is a built-in feature for managing users: listing and
+// searching them, enabling and disabling accounts, forcing password resets,
+// assigning roles and revoking sessions, impersonating users for support,
+// inviting people when registration is invite only, switching feature flags,
+// watching scheduled tasks, plus browsing the audit log. Everything is behind
+// the admin permission, give it to the first admin with AUTH_ADMIN_EMAILS.
+package admin
+
+import (
+	"embed"
+	"net/http"
+
+	"github.com/MickDuprez/gobase/core/auth"
+	"github.com/MickDuprez/gobase/core/interfaces"
+)
+
+//go:embed templates
+var templates embed.FS
+
+func New() interfaces.Feature {
+	return interfaces.Feature{
+		Name:  "admin",
+		FS:    templates,
+		Mount: "/admin",
+		NavItems: []interfaces.NavItem{
+			{
+				Title: "Admin",
+				SubItems: []interfaces.NavItem{
+					{
+						Title:    "Users",
+						URL:      "/users",
+						Priority: 10,
+					},
+					{
+						Title:    "Invitations",
+						URL:      "/invitations",
+						Priority: 15,
+					},
+					{
+						Title:    "Audit log",
+						URL:      "/audit",
+						Priority: 20,
+					},
+					{
+						Title:    "Feature flags",
+						URL:      "/flags",
+						Priority: 25,
+					},
+					{
+						Title:    "Scheduled tasks",
+						URL:      "/schedule",
+						Priority: 30,
+					},
+				},
+			},
+		},
+		// the admin pages need somewhere to log in
+		DependsOn: []string{"login"},
+		Routes:    setupRoutes,
+	}
+}
+
+func setupRoutes(app interfaces.App) {
+	h := &Handler{app: app}
+	// paths are relative to the feature's mount, "/admin" unless the
+	// features config moves it
+	admin := app.Group("/", requirePermission(app, auth.PermAdmin))
+
+	admin.Handle("GET /", h.index).Name("admin.index")
+	admin.Handle("GET /users", h.users).Name("admin.users")
+	admin.Handle("GET /users/{id}", h.user).Name("admin.user")
+	admin.Handle("GET /invitations", h.invitations).Name("admin.invitations")
+	admin.Handle("GET /audit", h.auditLog).Name("admin.audit")
+	admin.Handle("GET /audit.csv", h.exportAudit).Name("admin.audit.export")
+	admin.Handle("GET /flags", h.flagsPage).Name("admin.flags")
+	admin.Handle("GET /schedule", h.schedulePage).Name("admin.schedule")
+
+	// htmx routes
+	admin.Handle("POST /users/{id}/disable", h.disableUser).Name("admin.user.disable")
+	admin.Handle("POST /users/{id}/enable", h.enableUser).Name("admin.user.enable")
+	admin.Handle("POST /users/{id}/reset-password", h.resetPassword).Name("admin.user.reset_password")
+	admin.Handle("POST /users/{id}/roles", h.setRole).Name("admin.user.roles")
+	admin.Handle("POST /users/{id}/sessions/revoke", h.revokeSessions).Name("admin.user.sessions.revoke")
+	admin.Handle("POST /users/{id}/sessions/{key}/revoke", h.revokeSession).Name("admin.user.session.revoke")
+	admin.Handle("POST /invitations", h.createInvitation)
+	admin.Handle("POST /invitations/{id}/revoke", h.revokeInvitation).Name("admin.invitation.revoke")
+	admin.Handle("POST /flags", h.createFlag)
+	admin.Handle("POST /flags/{name}", h.saveFlag).Name("admin.flag")
+	admin.Handle("GET /schedule/tasks", h.taskList).Name("admin.schedule.tasks")
+	admin.Handle("POST /schedule/{name}/run", h.runTask).Name("admin.schedule.run")
+
+	// Impersonation, ending it is done as the impersonated user so only
+	// needs a login
+	support := app.Group("/", requirePermission(app, auth.PermImpersonate))
+	support.Handle("POST /users/{id}/impersonate", h.impersonate).Name("admin.user.impersonate")
+	app.Handle("POST /impersonation/end", app.RequireAuth(h.endImpersonation)).Name("admin.impersonation.end")
+	auth.ImpersonationEndURL = h.path("admin.impersonation.end")
+}
+
+// path builds the path of one of the routes named above, wherever the
+// feature is mounted. They're all registered together so only a typo makes
+// it fail, and that's a bug.
+func (h *Handler) path(name string, params ...interface{}) string {
+	p, err := h.app.URL(name, params...)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func requirePermission(app interfaces.App, perm string) interfaces.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return app.RequirePermission(perm, next)
+	}
+}
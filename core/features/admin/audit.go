@@ -0,0 +1,113 @@
+package admin
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/MickDuprez/gobase/core/auth"
+	"github.com/MickDuprez/gobase/core/middleware"
+)
+
+type auditList struct {
+	Events   []*auth.AuditEvent
+	Query    url.Values   // the filters, to fill in the form
+	Export   template.URL // the CSV of the same events
+	Total    int
+	Page     int
+	Pages    int
+	PrevPage template.URL
+	NextPage template.URL
+}
+
+// auditFilter reads the filter form. Dates are whole days, until includes
+// the day given.
+func auditFilter(q url.Values) auth.AuditFilter {
+	f := auth.AuditFilter{
+		Type:  q.Get("type"),
+		Email: q.Get("email"),
+		IP:    q.Get("ip"),
+	}
+	f.UserID, _ = strconv.ParseInt(q.Get("user"), 10, 64)
+	if t, err := time.ParseInLocation("2006-01-02", q.Get("since"), time.Local); err == nil {
+		f.Since = t
+	}
+	if t, err := time.ParseInLocation("2006-01-02", q.Get("until"), time.Local); err == nil {
+		f.Until = t.AddDate(0, 0, 1)
+	}
+	return f
+}
+
+// filterQuery keeps just the filters from q, dropping the page
+func filterQuery(q url.Values) url.Values {
+	kept := url.Values{}
+	for _, key := range []string{"type", "user", "email", "ip", "since", "until"} {
+		if v := q.Get(key); v != "" {
+			kept.Set(key, v)
+		}
+	}
+	return kept
+}
+
+func (h *Handler) auditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	f := auditFilter(q)
+	f.Offset = (page - 1) * pageSize
+	f.Limit = pageSize
+
+	events, total, err := h.app.Auth().QueryAudit(f)
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to load audit log: %w", err))
+		return
+	}
+
+	filters := filterQuery(q)
+	list := auditList{
+		Events: events,
+		Query:  filters,
+		Export: template.URL(h.path("admin.audit.export") + "?" + filters.Encode()),
+		Total:  total,
+		Page:   page,
+		Pages:  (total + pageSize - 1) / pageSize,
+	}
+	if page > 1 {
+		list.PrevPage = auditPageURL(h.path("admin.audit"), filters, page-1)
+	}
+	if page < list.Pages {
+		list.NextPage = auditPageURL(h.path("admin.audit"), filters, page+1)
+	}
+
+	if middleware.IsHTMX(r) {
+		h.app.RenderPartial(w, r, "admin", "audit_table", list)
+		return
+	}
+	h.app.RenderTemplate(w, r, "admin", "audit", list)
+}
+
+func auditPageURL(base string, filters url.Values, page int) template.URL {
+	q := url.Values{}
+	for k, v := range filters {
+		q[k] = v
+	}
+	q.Set("page", strconv.Itoa(page))
+	return template.URL(base + "?" + q.Encode())
+}
+
+func (h *Handler) exportAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-`+time.Now().Format("20060102-150405")+`.csv"`)
+
+	if err := h.app.Auth().ExportAuditCSV(w, auditFilter(r.URL.Query())); err != nil {
+		// too late for an error page, the download will be cut short
+		log.Printf("Error exporting audit log: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/MickDuprez/gobase/core/auth"
+	"github.com/MickDuprez/gobase/core/flags"
+)
+
+type flagList struct {
+	Flags   []flagRow
+	Name    string
+	Message string
+	Error   string
+}
+
+// flagRow is a flag with the roles it can be turned on for
+type flagRow struct {
+	*flags.Flag
+	Roles []roleOption
+}
+
+func (h *Handler) loadFlags(w http.ResponseWriter, r *http.Request) (*flagList, bool) {
+	roles, err := h.app.Auth().ListRoles()
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to load roles: %w", err))
+		return nil, false
+	}
+	list := &flagList{}
+	for _, flag := range h.app.Flags().List() {
+		row := flagRow{Flag: flag}
+		for _, role := range roles {
+			opt := roleOption{Role: role}
+			for _, name := range flag.Roles {
+				if name == role.Name {
+					opt.Assigned = true
+				}
+			}
+			row.Roles = append(row.Roles, opt)
+		}
+		list.Flags = append(list.Flags, row)
+	}
+	return list, true
+}
+
+func (h *Handler) flagsPage(w http.ResponseWriter, r *http.Request) {
+	list, ok := h.loadFlags(w, r)
+	if !ok {
+		return
+	}
+	h.app.RenderTemplate(w, r, "admin", "flags", list)
+}
+
+func (h *Handler) createFlag(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	name := strings.TrimSpace(r.FormValue("name"))
+
+	var err error
+	if h.app.Flags().Get(name) != nil {
+		err = fmt.Errorf("%w: there's already a flag called %s", flags.ErrInvalidFlag, name)
+	} else {
+		err = h.app.Flags().Define(name, strings.TrimSpace(r.FormValue("description")))
+	}
+
+	list, ok := h.loadFlags(w, r)
+	if !ok {
+		return
+	}
+	switch {
+	case errors.Is(err, flags.ErrInvalidFlag):
+		list.Name = name
+		list.Error = err.Error()
+	case err != nil:
+		log.Printf("Error creating flag: %v", err)
+		list.Error = "Something went wrong, please try again."
+	default:
+		h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditFlagUpdate, Details: name + ": created"})
+		list.Message = "Flag " + name + " created, it's off until you turn it on."
+	}
+
+	h.app.RenderPartial(w, r, "admin", "flag_list", list)
+}
+
+func (h *Handler) saveFlag(w http.ResponseWriter, r *http.Request) {
+	flag := h.app.Flags().Get(r.PathValue("name"))
+	if flag == nil {
+		h.app.Error(w, r, http.StatusNotFound, nil)
+		return
+	}
+
+	r.ParseForm()
+	flag.Enabled = r.FormValue("enabled") != ""
+	flag.Roles = r.Form["roles"]
+	percentage, err := strconv.Atoi(r.FormValue("percentage"))
+	if err == nil {
+		flag.Percentage = percentage
+		flag.Users, err = parseUserIDs(r.FormValue("users"))
+	}
+	if err == nil {
+		err = h.app.Flags().Save(flag)
+	}
+
+	list, ok := h.loadFlags(w, r)
+	if !ok {
+		return
+	}
+	switch {
+	case errors.Is(err, flags.ErrInvalidFlag), errors.Is(err, strconv.ErrSyntax):
+		list.Error = "Flag " + flag.Name + " wasn't saved, " + err.Error()
+	case err != nil:
+		log.Printf("Error saving flag: %v", err)
+		list.Error = "Something went wrong, please try again."
+	default:
+		h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditFlagUpdate, Details: describeFlag(flag)})
+		list.Message = "Flag " + flag.Name + " saved."
+	}
+
+	h.app.RenderPartial(w, r, "admin", "flag_list", list)
+}
+
+// parseUserIDs reads a comma or space separated list of user IDs
+func parseUserIDs(s string) ([]int64, error) {
+	var ids []int64
+	for _, field := range strings.Fields(strings.ReplaceAll(s, ",", " ")) {
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't a user ID: %w", field, strconv.ErrSyntax)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// describeFlag is the flag's settings for the audit log
+func describeFlag(f *flags.Flag) string {
+	if f.Enabled {
+		return f.Name + ": on for everyone"
+	}
+	users := make([]string, len(f.Users))
+	for i, id := range f.Users {
+		users[i] = strconv.FormatInt(id, 10)
+	}
+	return fmt.Sprintf("%s: users [%s] roles [%s] %d%%",
+		f.Name, strings.Join(users, " "), strings.Join(f.Roles, " "), f.Percentage)
+}
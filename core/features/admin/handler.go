@@ -0,0 +1,282 @@
+package admin
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/MickDuprez/gobase/core/auth"
+	"github.com/MickDuprez/gobase/core/interfaces"
+	"github.com/MickDuprez/gobase/core/middleware"
+)
+
+// pageSize is how many users are listed per page
+const pageSize = 20
+
+type Handler struct {
+	app interfaces.App
+}
+
+type userList struct {
+	Users    []*auth.User
+	Search   string
+	Total    int
+	Page     int
+	Pages    int
+	PrevPage template.URL // empty when there's no such page
+	NextPage template.URL
+}
+
+type roleOption struct {
+	*auth.Role
+	Assigned bool
+}
+
+// userDetail is shared by the user page and its partials
+type userDetail struct {
+	User     *auth.User
+	Roles    []roleOption
+	Sessions []*auth.Session
+	Self     bool // the admin is looking at their own account
+	Message  string
+	Error    string
+}
+
+func (h *Handler) index(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, h.path("admin.users"), http.StatusSeeOther)
+}
+
+func (h *Handler) users(w http.ResponseWriter, r *http.Request) {
+	search := r.URL.Query().Get("q")
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	users, total, err := h.app.Auth().ListUsers(auth.UserQuery{
+		Search: search,
+		Offset: (page - 1) * pageSize,
+		Limit:  pageSize,
+	})
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to list users: %w", err))
+		return
+	}
+
+	list := userList{
+		Users:  users,
+		Search: search,
+		Total:  total,
+		Page:   page,
+		Pages:  (total + pageSize - 1) / pageSize,
+	}
+	if page > 1 {
+		list.PrevPage = pageURL(h.path("admin.users"), search, page-1)
+	}
+	if page < list.Pages {
+		list.NextPage = pageURL(h.path("admin.users"), search, page+1)
+	}
+
+	// searching and paging only swap the table
+	if middleware.IsHTMX(r) {
+		h.app.RenderPartial(w, r, "admin", "user_table", list)
+		return
+	}
+	h.app.RenderTemplate(w, r, "admin", "users", list)
+}
+
+func pageURL(base, search string, page int) template.URL {
+	q := url.Values{}
+	if search != "" {
+		q.Set("q", search)
+	}
+	q.Set("page", strconv.Itoa(page))
+	return template.URL(base + "?" + q.Encode())
+}
+
+// loadUser fills in everything the user page shows for the user in the url
+func (h *Handler) loadUser(w http.ResponseWriter, r *http.Request) (*userDetail, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.app.Error(w, r, http.StatusNotFound, nil)
+		return nil, false
+	}
+
+	user, err := h.app.Auth().GetUserByID(id)
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to load user: %w", err))
+		return nil, false
+	}
+	if user == nil {
+		h.app.Error(w, r, http.StatusNotFound, nil)
+		return nil, false
+	}
+
+	roles, err := h.app.Auth().ListRoles()
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to load roles: %w", err))
+		return nil, false
+	}
+	assigned, err := h.app.Auth().GetUserRoles(id)
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to load roles: %w", err))
+		return nil, false
+	}
+
+	sessions, err := h.app.Auth().ListSessions(id)
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to load sessions: %w", err))
+		return nil, false
+	}
+
+	detail := &userDetail{
+		User:     user,
+		Sessions: sessions,
+		Self:     auth.GetUser(r).ID == id,
+	}
+	for _, role := range roles {
+		opt := roleOption{Role: role}
+		for _, name := range assigned {
+			if name == role.Name {
+				opt.Assigned = true
+			}
+		}
+		detail.Roles = append(detail.Roles, opt)
+	}
+	return detail, true
+}
+
+func (h *Handler) user(w http.ResponseWriter, r *http.Request) {
+	detail, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+	h.app.RenderTemplate(w, r, "admin", "user", detail)
+}
+
+// update runs an action against the user in the url, then re-renders the
+// partial it affected
+func (h *Handler) update(w http.ResponseWriter, r *http.Request, partial string, action func(d *userDetail) (string, error)) {
+	detail, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	message, err := action(detail)
+	if err != nil {
+		log.Printf("Admin action on user %d failed: %v", detail.User.ID, err)
+		detail.Error = "Something went wrong, please try again."
+	} else if message != "" {
+		detail.Message = message
+	}
+
+	// reload so the partial shows the result
+	if fresh, ok := h.loadUser(w, r); ok {
+		fresh.Message, fresh.Error = detail.Message, detail.Error
+		h.app.RenderPartial(w, r, "admin", partial, fresh)
+	}
+}
+
+// record adds an admin action on the user in d to the audit log
+func (h *Handler) record(r *http.Request, d *userDetail, eventType, details string) {
+	h.app.Auth().Audit(r, auth.AuditEvent{
+		Type:    eventType,
+		UserID:  d.User.ID,
+		Email:   d.User.Email,
+		Details: details,
+	})
+}
+
+func (h *Handler) disableUser(w http.ResponseWriter, r *http.Request) {
+	h.update(w, r, "user_status", func(d *userDetail) (string, error) {
+		if d.Self {
+			d.Error = "You can't disable your own account."
+			return "", nil
+		}
+		if err := h.app.Auth().DisableUser(d.User.ID); err != nil {
+			return "", err
+		}
+		h.record(r, d, auth.AuditUserDisable, "")
+		return "Account disabled and logged out everywhere.", nil
+	})
+}
+
+func (h *Handler) enableUser(w http.ResponseWriter, r *http.Request) {
+	h.update(w, r, "user_status", func(d *userDetail) (string, error) {
+		if err := h.app.Auth().EnableUser(d.User.ID); err != nil {
+			return "", err
+		}
+		h.record(r, d, auth.AuditUserEnable, "")
+		return "Account enabled.", nil
+	})
+}
+
+func (h *Handler) resetPassword(w http.ResponseWriter, r *http.Request) {
+	h.update(w, r, "user_status", func(d *userDetail) (string, error) {
+		if d.Self {
+			d.Error = "Change your own password from your profile."
+			return "", nil
+		}
+		if err := h.app.Auth().ForcePasswordReset(d.User.ID); err != nil {
+			return "", err
+		}
+		h.record(r, d, auth.AuditPasswordReset, "")
+		return "The user will have to choose a new password when they next log in.", nil
+	})
+}
+
+func (h *Handler) setRole(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	role := r.FormValue("role")
+	assign := r.FormValue("assign") == "on"
+
+	h.update(w, r, "user_roles", func(d *userDetail) (string, error) {
+		if assign {
+			if err := h.app.Auth().AssignRole(d.User.ID, role); err != nil {
+				return "", err
+			}
+			h.record(r, d, auth.AuditRoleAssign, role)
+			return "Role " + role + " assigned.", nil
+		}
+		// stop admins locking themselves out
+		if d.Self && role == "admin" {
+			d.Error = "You can't remove your own admin role."
+			return "", nil
+		}
+		if err := h.app.Auth().RemoveRole(d.User.ID, role); err != nil {
+			return "", err
+		}
+		h.record(r, d, auth.AuditRoleRemove, role)
+		return "Role " + role + " removed.", nil
+	})
+}
+
+func (h *Handler) revokeSessions(w http.ResponseWriter, r *http.Request) {
+	h.update(w, r, "user_sessions", func(d *userDetail) (string, error) {
+		// keep the admin's own session when looking at their own account
+		keep := ""
+		if d.Self {
+			if cookie, err := r.Cookie("session_id"); err == nil {
+				keep = cookie.Value
+			}
+		}
+		if err := h.app.Auth().RevokeSessions(d.User.ID, keep); err != nil {
+			return "", err
+		}
+		h.record(r, d, auth.AuditSessionRevoke, "all")
+		return "Sessions revoked.", nil
+	})
+}
+
+func (h *Handler) revokeSession(w http.ResponseWriter, r *http.Request) {
+	h.update(w, r, "user_sessions", func(d *userDetail) (string, error) {
+		if err := h.app.Auth().RevokeSessionByKey(d.User.ID, r.PathValue("key")); err != nil {
+			return "", err
+		}
+		h.record(r, d, auth.AuditSessionRevoke, r.PathValue("key"))
+		return "Session revoked.", nil
+	})
+}
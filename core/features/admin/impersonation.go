@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/MickDuprez/gobase/core/auth"
+)
+
+func (h *Handler) impersonate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.app.Error(w, r, http.StatusNotFound, nil)
+		return
+	}
+
+	// token requests have no session to go back to
+	session := auth.GetCurrentSession(r)
+	if session == nil {
+		h.app.Error(w, r, http.StatusBadRequest, errors.New("Impersonation needs a browser session"))
+		return
+	}
+
+	impersonation, err := h.app.Auth().StartImpersonation(session, id)
+	if errors.Is(err, auth.ErrCannotImpersonate) {
+		http.Redirect(w, r, h.path("admin.user", id)+"?error=cannot_impersonate", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to start impersonation: %w", err))
+		return
+	}
+
+	h.app.Auth().Audit(r, auth.AuditEvent{
+		Type:    auth.AuditImpersonationStart,
+		UserID:  id,
+		ActorID: session.UserID,
+	})
+
+	auth.SetSessionCookie(w, impersonation)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (h *Handler) endImpersonation(w http.ResponseWriter, r *http.Request) {
+	session := auth.GetCurrentSession(r)
+	if session == nil || !session.Impersonated() {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// record it while the request still belongs to the impersonation
+	h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditImpersonationEnd, UserID: session.UserID})
+
+	original, err := h.app.Auth().EndImpersonation(session)
+	if errors.Is(err, auth.ErrSessionEnded) {
+		auth.ClearSessionCookie(w)
+		http.Redirect(w, r, auth.LoginURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to end impersonation: %w", err))
+		return
+	}
+
+	auth.SetSessionCookie(w, original)
+	http.Redirect(w, r, h.path("admin.user", session.UserID), http.StatusSeeOther)
+}
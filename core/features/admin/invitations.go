@@ -0,0 +1,109 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/MickDuprez/gobase/core/auth"
+	"github.com/MickDuprez/gobase/core/mail"
+)
+
+type invitationList struct {
+	Invitations []*auth.Invitation
+	Mode        string
+	Link        string // the link for a new invitation, shown once
+	Email       string
+	Message     string
+	Error       string
+	Errors      auth.ValidationErrors
+}
+
+func (h *Handler) loadInvitations(w http.ResponseWriter, r *http.Request) (*invitationList, bool) {
+	invitations, err := h.app.Auth().ListInvitations()
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to load invitations: %w", err))
+		return nil, false
+	}
+	return &invitationList{
+		Invitations: invitations,
+		Mode:        h.app.Auth().Config().RegistrationMode,
+	}, true
+}
+
+func (h *Handler) invitations(w http.ResponseWriter, r *http.Request) {
+	list, ok := h.loadInvitations(w, r)
+	if !ok {
+		return
+	}
+	h.app.RenderTemplate(w, r, "admin", "invitations", list)
+}
+
+func (h *Handler) createInvitation(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	email := r.FormValue("email")
+	admin := auth.GetUser(r)
+
+	inv, token, err := h.app.Auth().CreateInvitation(email, admin.ID)
+
+	list, ok := h.loadInvitations(w, r)
+	if !ok {
+		return
+	}
+
+	var invalid auth.ValidationErrors
+	switch {
+	case errors.As(err, &invalid):
+		list.Email = email
+		list.Errors = invalid
+	case errors.Is(err, auth.ErrRegistrationClosed):
+		list.Error = "Registration is closed, invitations can't be used."
+	case err != nil:
+		log.Printf("Error creating invitation: %v", err)
+		list.Error = "Something went wrong, please try again."
+	default:
+		h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditInvitationCreate, Email: inv.Email})
+
+		list.Link = h.app.Auth().AbsoluteURL("/register?" + url.Values{"invite": {token}}.Encode())
+		err = h.app.Mailer().Send(r.Context(), &mail.Message{
+			To:      []string{inv.Email},
+			Subject: "You're invited",
+			Text: fmt.Sprintf("Hi,\n\n%s has invited you to create an account. Follow this link to register:\n\n%s\n\nThe link expires on %s.\n",
+				admin.Name, list.Link, inv.ExpiresAt.Format("2 January 2006")),
+		})
+		if err != nil {
+			log.Printf("Error sending invitation: %v", err)
+			list.Message = "The invitation was created but the email couldn't be sent, send the link yourself."
+		} else {
+			list.Message = "Invitation sent to " + inv.Email + "."
+		}
+	}
+
+	h.app.RenderPartial(w, r, "admin", "invitation_list", list)
+}
+
+func (h *Handler) revokeInvitation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.app.Error(w, r, http.StatusNotFound, nil)
+		return
+	}
+
+	err = h.app.Auth().RevokeInvitation(id)
+
+	list, ok := h.loadInvitations(w, r)
+	if !ok {
+		return
+	}
+	if err != nil {
+		list.Error = "The invitation has already been used or revoked."
+	} else {
+		h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditInvitationRevoke, Details: "invitation " + r.PathValue("id")})
+		list.Message = "Invitation revoked."
+	}
+
+	h.app.RenderPartial(w, r, "admin", "invitation_list", list)
+}
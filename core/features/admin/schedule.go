@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/MickDuprez/gobase/core/auth"
+	"github.com/MickDuprez/gobase/core/schedule"
+)
+
+type taskList struct {
+	Tasks   []schedule.TaskInfo
+	Runs    []*schedule.Run
+	Message string
+	Error   string
+}
+
+// historyLimit is how many recent runs the page shows
+const historyLimit = 50
+
+func (h *Handler) loadTasks(w http.ResponseWriter, r *http.Request) (*taskList, bool) {
+	tasks, err := h.app.Scheduler().Tasks()
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to load tasks: %w", err))
+		return nil, false
+	}
+	runs, err := h.app.Scheduler().History("", historyLimit)
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to load task history: %w", err))
+		return nil, false
+	}
+	return &taskList{Tasks: tasks, Runs: runs}, true
+}
+
+func (h *Handler) schedulePage(w http.ResponseWriter, r *http.Request) {
+	list, ok := h.loadTasks(w, r)
+	if !ok {
+		return
+	}
+	h.app.RenderTemplate(w, r, "admin", "schedule", list)
+}
+
+// taskList is polled by the page, so runs show up as they happen
+func (h *Handler) taskList(w http.ResponseWriter, r *http.Request) {
+	list, ok := h.loadTasks(w, r)
+	if !ok {
+		return
+	}
+	h.app.RenderPartial(w, r, "admin", "task_list", list)
+}
+
+func (h *Handler) runTask(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	err := h.app.Scheduler().RunNow(name)
+	if errors.Is(err, schedule.ErrNotFound) {
+		h.app.Error(w, r, http.StatusNotFound, nil)
+		return
+	}
+
+	var message, errMessage string
+	switch {
+	case errors.Is(err, schedule.ErrRunning), errors.Is(err, schedule.ErrStopped):
+		errMessage = "Task " + name + " wasn't started, " + err.Error() + "."
+	case err != nil:
+		log.Printf("Error running task %s: %v", name, err)
+		errMessage = "Something went wrong, please try again."
+	default:
+		h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditTaskRun, Details: name})
+		message = "Task " + name + " started."
+	}
+
+	list, ok := h.loadTasks(w, r)
+	if !ok {
+		return
+	}
+	list.Message, list.Error = message, errMessage
+	h.app.RenderPartial(w, r, "admin", "task_list", list)
+}
@@ -0,0 +1,255 @@
+// Package flags switches features on at runtime for everyone, or only for
+// some users, roles or a percentage of users, without a redeploy
+package flags
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var ErrInvalidFlag = errors.New("invalid flag")
+
+// Flag is on for everyone when Enabled, otherwise only for the users it
+// targets
+type Flag struct {
+	Name        string
+	Description string
+	Enabled     bool
+	Users       []int64  // user IDs it's on for
+	Roles       []string // roles it's on for
+	Percentage  int      // 0 to 100, of logged in users
+	UpdatedAt   time.Time
+}
+
+// Subject is who a flag is checked for, the zero value is a visitor who
+// isn't logged in
+type Subject struct {
+	UserID int64
+	Roles  []string
+}
+
+// EnabledFor reports whether the flag is on for the subject. Users keep
+// their percentage bucket as the rollout grows, so raising the percentage
+// only ever adds users.
+func (f *Flag) EnabledFor(s Subject) bool {
+	if f.Enabled {
+		return true
+	}
+	if s.UserID == 0 {
+		return false
+	}
+
+	for _, id := range f.Users {
+		if id == s.UserID {
+			return true
+		}
+	}
+	for _, role := range f.Roles {
+		for _, r := range s.Roles {
+			if role == r {
+				return true
+			}
+		}
+	}
+	return bucket(f.Name, s.UserID) < f.Percentage
+}
+
+// bucket puts a user in one of 100 buckets, different for each flag so the
+// same users aren't always first to get new things
+func bucket(flag string, userID int64) int {
+	h := fnv.New32a()
+	h.Write([]byte(flag + ":" + strconv.FormatInt(userID, 10)))
+	return int(h.Sum32() % 100)
+}
+
+// Store keeps flags in SQLite, with a copy in memory as they're checked on
+// most requests
+type Store struct {
+	db *sql.DB
+
+	mu    sync.RWMutex
+	flags map[string]*Flag
+}
+
+// New opens the flag store at path, creating it if needed
+func New(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS flags (
+        name TEXT PRIMARY KEY,
+        description TEXT NOT NULL DEFAULT '',
+        enabled INTEGER NOT NULL DEFAULT 0,
+        users TEXT NOT NULL DEFAULT '',
+        roles TEXT NOT NULL DEFAULT '',
+        percentage INTEGER NOT NULL DEFAULT 0,
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	s := &Store{db: db}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// reload refreshes the copy in memory after a change
+func (s *Store) reload() error {
+	rows, err := s.db.Query(`SELECT name, description, enabled, users, roles, percentage, updated_at FROM flags`)
+	if err != nil {
+		return fmt.Errorf("failed to load flags: %w", err)
+	}
+	defer rows.Close()
+
+	flags := make(map[string]*Flag)
+	for rows.Next() {
+		var f Flag
+		var users, roles string
+		if err := rows.Scan(&f.Name, &f.Description, &f.Enabled, &users, &roles, &f.Percentage, &f.UpdatedAt); err != nil {
+			return fmt.Errorf("failed to load flags: %w", err)
+		}
+		for _, id := range strings.Fields(users) {
+			if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+				f.Users = append(f.Users, n)
+			}
+		}
+		f.Roles = strings.Fields(roles)
+		flags[f.Name] = &f
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load flags: %w", err)
+	}
+
+	s.mu.Lock()
+	s.flags = flags
+	s.mu.Unlock()
+	return nil
+}
+
+// Define adds a flag, off, if it doesn't exist yet. Features call it at
+// startup for the flags they check so they show up in the admin pages.
+func (s *Store) Define(name, description string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec(
+		`INSERT INTO flags (name, description) VALUES (?, ?)
+         ON CONFLICT(name) DO UPDATE SET description = excluded.description`,
+		name, description,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to define flag %s: %w", name, err)
+	}
+	return s.reload()
+}
+
+// validName keeps names to something that can go in a URL untouched
+func validName(name string) error {
+	if name == "" || len(name) > 64 {
+		return fmt.Errorf("%w: names are 1 to 64 characters", ErrInvalidFlag)
+	}
+	for _, c := range name {
+		ok := c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.'
+		if !ok {
+			return fmt.Errorf("%w: names can only have lowercase letters, digits, '-', '_' and '.'", ErrInvalidFlag)
+		}
+	}
+	return nil
+}
+
+// Get returns the flag, nil when there's no such flag
+func (s *Store) Get(name string) *Flag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	f, ok := s.flags[name]
+	if !ok {
+		return nil
+	}
+	copied := *f
+	return &copied
+}
+
+// List returns all the flags by name
+func (s *Store) List() []*Flag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	flags := make([]*Flag, 0, len(s.flags))
+	for _, f := range s.flags {
+		copied := *f
+		flags = append(flags, &copied)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+// Save stores the flag's switch and targeting, creating it if needed
+func (s *Store) Save(f *Flag) error {
+	if err := validName(f.Name); err != nil {
+		return err
+	}
+	if f.Percentage < 0 || f.Percentage > 100 {
+		return fmt.Errorf("%w: percentage must be between 0 and 100", ErrInvalidFlag)
+	}
+
+	users := make([]string, len(f.Users))
+	for i, id := range f.Users {
+		users[i] = strconv.FormatInt(id, 10)
+	}
+
+	_, err := s.db.Exec(
+		`INSERT INTO flags (name, description, enabled, users, roles, percentage, updated_at)
+         VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+         ON CONFLICT(name) DO UPDATE SET description = excluded.description, enabled = excluded.enabled,
+             users = excluded.users, roles = excluded.roles, percentage = excluded.percentage,
+             updated_at = CURRENT_TIMESTAMP`,
+		f.Name, f.Description, f.Enabled, strings.Join(users, " "), strings.Join(f.Roles, " "), f.Percentage,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save flag %s: %w", f.Name, err)
+	}
+	return s.reload()
+}
+
+func (s *Store) Delete(name string) error {
+	if _, err := s.db.Exec(`DELETE FROM flags WHERE name = ?`, name); err != nil {
+		return fmt.Errorf("failed to delete flag %s: %w", name, err)
+	}
+	return s.reload()
+}
+
+// Enabled reports whether the flag is on for the subject, flags that don't
+// exist are off
+func (s *Store) Enabled(name string, subject Subject) bool {
+	s.mu.RLock()
+	f, ok := s.flags[name]
+	s.mu.RUnlock()
+
+	return ok && f.EnabledFor(subject)
+}
@@ -1,20 +1,68 @@
 package interfaces
 
 import (
+	"context"
+	"io/fs"
 	"net/http"
+	"strings"
 
 	"github.com/MickDuprez/gobase/core/auth"
 	"github.com/MickDuprez/gobase/core/database"
+	"github.com/MickDuprez/gobase/core/events"
+	"github.com/MickDuprez/gobase/core/flags"
+	"github.com/MickDuprez/gobase/core/jobs"
+	"github.com/MickDuprez/gobase/core/mail"
+	"github.com/MickDuprez/gobase/core/schedule"
 )
 
+// Middleware wraps a handler, e.g. App.RequireAuth
+type Middleware func(http.HandlerFunc) http.HandlerFunc
+
+// Route is a registered route
+type Route interface {
+	// Name lets the route be found by App.URL and the url template helper,
+	// names are app wide so prefix them with the feature, e.g. "users.login"
+	Name(name string) Route
+}
+
+// Router registers routes, either on the app itself or on a group
+type Router interface {
+	Handle(pattern string, handler http.HandlerFunc) Route
+	// Group returns a router that puts prefix in front of its patterns and
+	// runs mw, first to last, before their handlers
+	Group(prefix string, mw ...Middleware) Router
+}
+
 type App interface {
-	Handle(pattern string, handler http.HandlerFunc)
+	Router
 	RenderTemplate(w http.ResponseWriter, r *http.Request, feature, page string, data interface{}) error
 	RenderPartial(w http.ResponseWriter, r *http.Request, feature, partial string, data interface{}) error
 	RegisterFeature(f Feature) error
+	// Feature returns a registered feature, to use its API
+	Feature(name string) (Feature, bool)
+	// Use adds middleware run on every request to the app
+	Use(mw ...Middleware)
+	// Error writes an error page, or JSON or an htmx fragment when the
+	// request wants one
+	Error(w http.ResponseWriter, r *http.Request, status int, err error)
+	// URL builds the path for a named route, filling its wildcards in order
+	URL(name string, params ...interface{}) (string, error)
 	Auth() *auth.AuthDB
 	RequireAuth(next http.HandlerFunc) http.HandlerFunc
+	RequireToken(next http.HandlerFunc) http.HandlerFunc
+	RequirePermission(perm string, next http.HandlerFunc) http.HandlerFunc
 	DB() *database.DB
+	Mailer() mail.Mailer
+	// Events is the bus features publish and subscribe to events on, with
+	// events.Subscribe(app.Events(), fn), auth's events included
+	Events() *events.Bus
+	// Jobs is the queue for work done outside the request, with retries
+	Jobs() *jobs.Queue
+	// Scheduler runs recurring tasks, add them in the feature's OnInit
+	Scheduler() *schedule.Scheduler
+	Flags() *flags.Store
+	// FlagEnabled reports whether a runtime flag is on for the request's user
+	FlagEnabled(r *http.Request, name string) bool
 
 	// Session helpers
 	SessionSetValue(r *http.Request, key string, value interface{}) error
@@ -24,11 +72,71 @@ type App interface {
 }
 
 type Feature struct {
-	Name     string
-	Path     string
-	NavItems []NavItem
-	Routes   func(app App)
-	OnInit   func(app App) error // Optional initialization
+	Name string
+	Path string
+	FS   fs.FS // Optional, templates are read from here instead of Path
+	// Mount is the base path for the feature's routes, nav URLs and url
+	// template helper, so "GET /team" in a feature mounted at "/about" is
+	// served at /about/team. Empty mounts at the root.
+	Mount string
+	// Middleware wraps every route the feature registers, first to last,
+	// outside any middleware of its groups
+	Middleware []Middleware
+	NavItems   []NavItem
+	Routes     func(app App)
+	// OnInit is optional initialization, run when the app is initialized once
+	// every feature is registered: after the feature's routes are set up and
+	// after the OnInit of the features it depends on
+	OnInit func(app App) error
+	// DependsOn names the features, or what they provide, that this feature
+	// needs. They're set up and started before it and shut down after it.
+	DependsOn []string
+	// Provides names what the feature offers other features besides its own
+	// name, e.g. "login", so a dependency can be on any feature that does
+	Provides []string
+	// API is what the feature offers other features, found with App.Feature
+	API interface{}
+	// Settings points to the feature's settings, with their defaults. The
+	// feature's block in the features config is decoded into it before
+	// Routes and OnInit run.
+	Settings interface{}
+	// Optional hooks run by App.Run, OnStart before the first request and
+	// OnShutdown once requests have drained, in reverse order
+	OnStart       func(ctx context.Context, app App) error
+	OnShutdown    func(ctx context.Context, app App) error
+	ProfileFields []auth.ProfileField // Optional fields stored in each user's profile
+	// Jobs returns the handlers for the feature's job types, named like
+	// routes, e.g. "users.welcome". Jobs are queued with App.Jobs().Enqueue.
+	Jobs func(app App) map[string]jobs.Handler
+}
+
+// MountAt returns a copy of the feature mounted at prefix instead of its
+// default, e.g. app.RegisterFeature(about.New().MountAt("/company"))
+func (f Feature) MountAt(prefix string) Feature {
+	f.Mount = strings.TrimSuffix(prefix, "/")
+	return f
+}
+
+// URL resolves a path within the feature to a path on the site
+func (f Feature) URL(path string) string {
+	return JoinPath(f.Mount, path)
+}
+
+// JoinPath puts prefix in front of path. A path of "/" means the prefix
+// itself, so the root of a feature mounted at "/about" is "/about" rather
+// than "/about/". Full URLs are returned unchanged.
+func JoinPath(prefix, path string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" || strings.Contains(path, "://") {
+		return path
+	}
+	if path == "/" || path == "" {
+		return prefix
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return prefix + path
 }
 
 type NavItem struct {
@@ -0,0 +1,100 @@
+// Package jobs runs deferred work, like sending email or building reports,
+// outside the request that asked for it. Jobs are kept in SQLite so they
+// survive a restart, failed ones are retried with exponential backoff and
+// ones that keep failing are set aside as dead for someone to look at.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/MickDuprez/gobase/core/utils"
+)
+
+var (
+	// ErrDuplicate is returned when a job with the same unique key is
+	// already queued or running
+	ErrDuplicate = errors.New("job is already queued")
+	ErrNotFound  = errors.New("job not found")
+)
+
+// Job statuses, jobs that succeed are deleted
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDead    = "dead" // out of attempts
+)
+
+type Job struct {
+	ID          int64
+	Type        string
+	Payload     []byte // JSON
+	Status      string
+	Attempts    int // including the one running
+	MaxAttempts int
+	UniqueKey   string
+	LastError   string
+	RunAt       time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Decode unmarshals the job's payload into v
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Handler runs a job of one type. Returning an error, or panicking, retries
+// it later. ctx is cancelled when the app shuts down, the job is then put
+// back without using up an attempt.
+type Handler func(ctx context.Context, job *Job) error
+
+// Options change how a job is queued, the zero value runs it as soon as a
+// worker is free
+type Options struct {
+	// UniqueKey stops the job being queued while another with the same key
+	// is queued or running, e.g. "report:42"
+	UniqueKey string
+	// Delay is how long to wait before running it
+	Delay time.Duration
+	// MaxAttempts overrides the config's
+	MaxAttempts int
+}
+
+type Config struct {
+	// Workers is how many jobs run at once, 0 only queues them, for
+	// processes that shouldn't run jobs
+	Workers int
+	// PollInterval is how often idle workers look for jobs that have
+	// become due, new jobs wake them straight away
+	PollInterval time.Duration
+	MaxAttempts  int
+	// Backoff is the wait before the first retry, it doubles for each
+	// after that up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+func NewConfig() *Config {
+	return &Config{
+		Workers:      utils.GetEnvInt("JOBS_WORKERS", 2),
+		PollInterval: time.Duration(utils.GetEnvInt("JOBS_POLL_INTERVAL_SECONDS", 5)) * time.Second,
+		MaxAttempts:  utils.GetEnvInt("JOBS_MAX_ATTEMPTS", 5),
+		Backoff:      time.Duration(utils.GetEnvInt("JOBS_BACKOFF_SECONDS", 10)) * time.Second,
+		MaxBackoff:   time.Duration(utils.GetEnvInt("JOBS_MAX_BACKOFF_SECONDS", 3600)) * time.Second,
+	}
+}
+
+// backoff is how long to wait before the next try, after attempts tries
+func (c *Config) backoff(attempts int) time.Duration {
+	d := c.Backoff
+	for i := 1; i < attempts && d < c.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+	return d
+}
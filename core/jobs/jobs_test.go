@@ -0,0 +1,35 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	cfg := &Config{Backoff: 10 * time.Second, MaxBackoff: time.Hour}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, 80 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour}, // 5120s, capped
+		{1000, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := cfg.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+
+	// a first wait above the cap is capped too
+	cfg = &Config{Backoff: 2 * time.Hour, MaxBackoff: time.Hour}
+	if got := cfg.backoff(1); got != time.Hour {
+		t.Errorf("backoff(1) above the cap = %v, want %v", got, time.Hour)
+	}
+}
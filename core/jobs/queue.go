@@ -0,0 +1,396 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const jobColumns = `id, type, payload, status, attempts, max_attempts, unique_key, last_error, run_at, created_at, updated_at`
+
+// Queue stores jobs and runs them on a pool of workers
+type Queue struct {
+	db  *sql.DB
+	cfg *Config
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+
+	claimMu sync.Mutex    // one worker takes a job at a time
+	wake    chan struct{} // tells an idle worker there's a new job
+	stop    context.CancelFunc
+	running sync.WaitGroup
+}
+
+// New opens the queue at path, creating it if needed. Workers don't run
+// until Start.
+func New(path string, cfg *Config) (*Queue, error) {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// workers write all the time, one connection saves them tripping over
+	// SQLite's lock
+	db.SetMaxOpenConns(1)
+
+	migrations := []string{
+		`CREATE TABLE IF NOT EXISTS jobs (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            type TEXT NOT NULL,
+            payload BLOB NOT NULL,
+            status TEXT NOT NULL DEFAULT 'queued',
+            attempts INTEGER NOT NULL DEFAULT 0,
+            max_attempts INTEGER NOT NULL,
+            unique_key TEXT,
+            last_error TEXT NOT NULL DEFAULT '',
+            run_at DATETIME NOT NULL,
+            created_at DATETIME NOT NULL,
+            updated_at DATETIME NOT NULL
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs(status, run_at);`,
+		// dead jobs don't count, so the work can be queued again
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_unique ON jobs(unique_key)
+         WHERE unique_key IS NOT NULL AND status != 'dead';`,
+	}
+	for _, m := range migrations {
+		if _, err := db.Exec(m); err != nil {
+			return nil, fmt.Errorf("failed to run migrations: %w", err)
+		}
+	}
+
+	return &Queue{
+		db:       db,
+		cfg:      cfg,
+		handlers: make(map[string]Handler),
+		wake:     make(chan struct{}, 1),
+	}, nil
+}
+
+func (q *Queue) Close() error {
+	return q.db.Close()
+}
+
+// Register sets the handler for jobs of jobType, types are app wide so
+// prefix them with the feature, e.g. "users.welcome"
+func (q *Queue) Register(jobType string, h Handler) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.handlers[jobType]; ok {
+		return fmt.Errorf("job type %q registered twice", jobType)
+	}
+	q.handlers[jobType] = h
+	return nil
+}
+
+// Enqueue stores a job of jobType with payload marshalled to JSON, opts
+// can be nil. It returns ErrDuplicate when opts has a unique key that's
+// already queued or running.
+func (q *Queue) Enqueue(jobType string, payload interface{}, opts *Options) (int64, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode payload: %w", err)
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = q.cfg.MaxAttempts
+	}
+	var uniqueKey sql.NullString
+	if opts.UniqueKey != "" {
+		uniqueKey = sql.NullString{String: opts.UniqueKey, Valid: true}
+	}
+
+	now := time.Now().UTC()
+	result, err := q.db.Exec(
+		`INSERT OR IGNORE INTO jobs (type, payload, max_attempts, unique_key, run_at, created_at, updated_at)
+         VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		jobType, data, maxAttempts, uniqueKey, now.Add(opts.Delay), now, now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to queue job: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, ErrDuplicate
+	}
+
+	q.notify()
+	return result.LastInsertId()
+}
+
+// notify wakes an idle worker, if there is one
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the workers until Stop. Jobs left running when the app last
+// stopped are queued again.
+func (q *Queue) Start() error {
+	if q.cfg.Workers <= 0 {
+		log.Printf("Job workers are off, jobs will only be queued")
+		return nil
+	}
+
+	_, err := q.db.Exec(
+		`UPDATE jobs SET status = ?, updated_at = ? WHERE status = ?`,
+		StatusQueued, time.Now().UTC(), StatusRunning,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to requeue interrupted jobs: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q.stop = cancel
+	for i := 0; i < q.cfg.Workers; i++ {
+		q.running.Add(1)
+		go q.work(ctx)
+	}
+	log.Printf("Started %d job workers", q.cfg.Workers)
+	return nil
+}
+
+// Stop cancels the running jobs and waits, until ctx is done, for the
+// workers to put them back
+func (q *Queue) Stop(ctx context.Context) error {
+	if q.stop == nil {
+		return nil
+	}
+	q.stop()
+
+	done := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for job workers: %w", ctx.Err())
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	defer q.running.Done()
+
+	for ctx.Err() == nil {
+		job, err := q.claim()
+		if err != nil {
+			log.Printf("Error taking a job: %v", err)
+		}
+		if job != nil {
+			q.run(ctx, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-time.After(q.cfg.PollInterval):
+		}
+	}
+}
+
+// claim marks the next due job that has a handler as running and returns
+// it, or nil when there isn't one. Jobs of types nobody handles, say of a
+// feature that's been turned off, wait in the queue.
+func (q *Queue) claim() (*Job, error) {
+	q.mu.RLock()
+	types := make([]interface{}, 0, len(q.handlers))
+	for t := range q.handlers {
+		types = append(types, t)
+	}
+	q.mu.RUnlock()
+	if len(types) == 0 {
+		return nil, nil
+	}
+
+	q.claimMu.Lock()
+	defer q.claimMu.Unlock()
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	args := append([]interface{}{StatusQueued, now}, types...)
+	job, err := scanJob(tx.QueryRow(
+		`SELECT `+jobColumns+` FROM jobs
+         WHERE status = ? AND run_at <= ? AND type IN (?`+strings.Repeat(", ?", len(types)-1)+`)
+         ORDER BY run_at, id LIMIT 1`,
+		args...,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE jobs SET status = ?, attempts = attempts + 1, updated_at = ? WHERE id = ?`,
+		StatusRunning, now, job.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	job.Status = StatusRunning
+	job.Attempts++
+	return job, nil
+}
+
+// run runs the job and records how it went: done jobs are deleted, failed
+// ones retried after a backoff until they run out of attempts
+func (q *Queue) run(ctx context.Context, job *Job) {
+	q.mu.RLock()
+	h := q.handlers[job.Type]
+	q.mu.RUnlock()
+
+	err := call(ctx, h, job)
+	now := time.Now().UTC()
+
+	switch {
+	case err == nil:
+		_, err = q.db.Exec(`DELETE FROM jobs WHERE id = ?`, job.ID)
+
+	case ctx.Err() != nil:
+		// stopped part way, it gets the attempt back
+		_, err = q.db.Exec(
+			`UPDATE jobs SET status = ?, attempts = attempts - 1, updated_at = ? WHERE id = ?`,
+			StatusQueued, now, job.ID,
+		)
+
+	case job.Attempts >= job.MaxAttempts:
+		log.Printf("Job %d (%s) failed %d times, giving up: %v", job.ID, job.Type, job.Attempts, err)
+		_, err = q.db.Exec(
+			`UPDATE jobs SET status = ?, last_error = ?, updated_at = ? WHERE id = ?`,
+			StatusDead, err.Error(), now, job.ID,
+		)
+
+	default:
+		wait := q.cfg.backoff(job.Attempts)
+		log.Printf("Job %d (%s) failed, attempt %d of %d, retrying in %v: %v", job.ID, job.Type, job.Attempts, job.MaxAttempts, wait, err)
+		_, err = q.db.Exec(
+			`UPDATE jobs SET status = ?, last_error = ?, run_at = ?, updated_at = ? WHERE id = ?`,
+			StatusQueued, err.Error(), now.Add(wait), now, job.ID,
+		)
+	}
+
+	if err != nil {
+		log.Printf("Error saving the result of job %d: %v", job.ID, err)
+	}
+}
+
+// call runs the handler, turning a panic into an error so the worker
+// carries on
+func call(ctx context.Context, h Handler, job *Job) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("panic in job %d (%s): %v\n%s", job.ID, job.Type, v, debug.Stack())
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return h(ctx, job)
+}
+
+// Dead returns the jobs that ran out of attempts, most recent first
+func (q *Queue) Dead() ([]*Job, error) {
+	rows, err := q.db.Query(
+		`SELECT `+jobColumns+` FROM jobs WHERE status = ? ORDER BY updated_at DESC, id DESC`,
+		StatusDead,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// Retry queues a dead job again with all its attempts. It returns
+// ErrDuplicate when a job with its unique key has been queued since.
+func (q *Queue) Retry(id int64) error {
+	now := time.Now().UTC()
+	result, err := q.db.Exec(
+		`UPDATE OR IGNORE jobs SET status = ?, attempts = 0, run_at = ?, updated_at = ? WHERE id = ? AND status = ?`,
+		StatusQueued, now, now, id, StatusDead,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var dead int
+		if err := q.db.QueryRow(`SELECT COUNT(*) FROM jobs WHERE id = ? AND status = ?`, id, StatusDead).Scan(&dead); err != nil {
+			return err
+		}
+		if dead > 0 {
+			return ErrDuplicate
+		}
+		return ErrNotFound
+	}
+
+	q.notify()
+	return nil
+}
+
+// Delete removes a job that isn't running
+func (q *Queue) Delete(id int64) error {
+	result, err := q.db.Exec(`DELETE FROM jobs WHERE id = ? AND status != ?`, id, StatusRunning)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var j Job
+	var uniqueKey sql.NullString
+	err := row.Scan(&j.ID, &j.Type, &j.Payload, &j.Status, &j.Attempts, &j.MaxAttempts,
+		&uniqueKey, &j.LastError, &j.RunAt, &j.CreatedAt, &j.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	j.UniqueKey = uniqueKey.String
+	return &j, nil
+}
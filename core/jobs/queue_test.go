@@ -0,0 +1,208 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T) *Queue {
+	t.Helper()
+	q, err := New(filepath.Join(t.TempDir(), "jobs.db"), &Config{
+		Workers:      1,
+		PollInterval: 10 * time.Millisecond,
+		MaxAttempts:  3,
+		Backoff:      time.Minute,
+		MaxBackoff:   time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { q.Close() })
+	return q
+}
+
+func getJob(t *testing.T, q *Queue, id int64) *Job {
+	t.Helper()
+	job, err := scanJob(q.db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id))
+	if err != nil {
+		t.Fatalf("job %d: %v", id, err)
+	}
+	return job
+}
+
+// runNext claims the next due job and runs it, as a worker would
+func runNext(t *testing.T, q *Queue) *Job {
+	t.Helper()
+	job, err := q.claim()
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if job != nil {
+		q.run(context.Background(), job)
+	}
+	return job
+}
+
+// makeDue brings a retry's run time forward so it can be claimed now
+func makeDue(t *testing.T, q *Queue, id int64) {
+	t.Helper()
+	if _, err := q.db.Exec(`UPDATE jobs SET run_at = ? WHERE id = ?`, time.Now().UTC().Add(-time.Second), id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryUntilDead(t *testing.T) {
+	q := newTestQueue(t)
+	calls := 0
+	q.Register("test.fail", func(ctx context.Context, job *Job) error {
+		calls++
+		if calls == 2 {
+			panic("boom")
+		}
+		return errors.New("no luck")
+	})
+
+	id, err := q.Enqueue("test.fail", map[string]int{"n": 1}, nil)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	// the first two failures are retried after the backoff, the panic counts
+	// as a failure
+	wantErrors := []string{"no luck", "panic: boom"}
+	for attempt := 1; attempt <= 2; attempt++ {
+		before := time.Now().UTC()
+		if runNext(t, q) == nil {
+			t.Fatalf("attempt %d: no job was due", attempt)
+		}
+		job := getJob(t, q, id)
+		if job.Status != StatusQueued || job.Attempts != attempt || job.LastError != wantErrors[attempt-1] {
+			t.Fatalf("attempt %d: got status %s, attempts %d, error %q", attempt, job.Status, job.Attempts, job.LastError)
+		}
+		wait := q.cfg.backoff(attempt)
+		if job.RunAt.Before(before.Add(wait)) || job.RunAt.After(time.Now().UTC().Add(wait)) {
+			t.Errorf("attempt %d: retry at %v, want %v after the attempt", attempt, job.RunAt, wait)
+		}
+
+		// not due until the backoff is over
+		if runNext(t, q) != nil {
+			t.Fatalf("attempt %d: retried before the backoff", attempt)
+		}
+		makeDue(t, q, id)
+	}
+
+	// the last attempt makes it dead
+	runNext(t, q)
+	job := getJob(t, q, id)
+	if job.Status != StatusDead || job.Attempts != 3 || job.LastError != "no luck" {
+		t.Fatalf("last attempt: got status %s, attempts %d, error %q", job.Status, job.Attempts, job.LastError)
+	}
+	if runNext(t, q) != nil {
+		t.Fatal("a dead job was run")
+	}
+	dead, err := q.Dead()
+	if err != nil || len(dead) != 1 || dead[0].ID != id {
+		t.Fatalf("Dead() = %v, %v, want job %d", dead, err, id)
+	}
+	if calls != 3 {
+		t.Errorf("handler called %d times, want 3", calls)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	q := newTestQueue(t)
+	fail := true
+	q.Register("test.report", func(ctx context.Context, job *Job) error {
+		if fail {
+			return errors.New("no luck")
+		}
+		return nil
+	})
+
+	opts := &Options{UniqueKey: "report:1", MaxAttempts: 1}
+	id, err := q.Enqueue("test.report", nil, opts)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if _, err := q.Enqueue("test.report", nil, opts); err != ErrDuplicate {
+		t.Fatalf("Enqueue with a queued unique key: %v, want ErrDuplicate", err)
+	}
+	if err := q.Retry(id); err != ErrNotFound {
+		t.Errorf("Retry of a queued job: %v, want ErrNotFound", err)
+	}
+
+	runNext(t, q)
+	if job := getJob(t, q, id); job.Status != StatusDead {
+		t.Fatalf("status %s after its only attempt, want dead", job.Status)
+	}
+
+	// a dead job doesn't hold on to its key, so retrying it clashes with
+	// one queued since
+	again, err := q.Enqueue("test.report", nil, opts)
+	if err != nil {
+		t.Fatalf("Enqueue with a dead job's unique key: %v", err)
+	}
+	if err := q.Retry(id); err != ErrDuplicate {
+		t.Fatalf("Retry with its key queued again: %v, want ErrDuplicate", err)
+	}
+	if err := q.Delete(again); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	// once that's gone it's queued again with all its attempts
+	if err := q.Retry(id); err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	job := getJob(t, q, id)
+	if job.Status != StatusQueued || job.Attempts != 0 {
+		t.Fatalf("after Retry got status %s, attempts %d", job.Status, job.Attempts)
+	}
+
+	fail = false
+	if runNext(t, q) == nil {
+		t.Fatal("retried job wasn't due")
+	}
+	if _, err := scanJob(q.db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id)); err == nil {
+		t.Error("job that succeeded wasn't deleted")
+	}
+	if err := q.Retry(id); err != ErrNotFound {
+		t.Errorf("Retry of a deleted job: %v, want ErrNotFound", err)
+	}
+}
+
+// Stopping the workers puts a running job back without using an attempt
+func TestStopPutsJobBack(t *testing.T) {
+	q := newTestQueue(t)
+	started := make(chan struct{})
+	q.Register("test.slow", func(ctx context.Context, job *Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	id, err := q.Enqueue("test.slow", nil, nil)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if err := q.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job didn't start")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	job := getJob(t, q, id)
+	if job.Status != StatusQueued || job.Attempts != 0 {
+		t.Errorf("after Stop got status %s, attempts %d, want queued with 0", job.Status, job.Attempts)
+	}
+}
@@ -0,0 +1,91 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/MickDuprez/gobase/core/utils"
+)
+
+type Message struct {
+	To      []string
+	Subject string
+	Text    string
+}
+
+// Mailer sends email, features should only depend on this interface
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+type Config struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func NewMailConfig() *Config {
+	return &Config{
+		Host:     utils.GetEnvStr("MAIL_HOST", ""),
+		Port:     utils.GetEnvStr("MAIL_PORT", "587"),
+		Username: utils.GetEnvStr("MAIL_USER", ""),
+		Password: utils.GetEnvStr("MAIL_PASSWORD", ""),
+		From:     utils.GetEnvStr("MAIL_FROM", "gobase@localhost"),
+	}
+}
+
+// New returns an SMTP mailer, or one that only logs messages when no mail
+// host is configured which is handy in development
+func New(cfg *Config) Mailer {
+	if cfg == nil || cfg.Host == "" {
+		return &LogMailer{}
+	}
+	return &SMTPMailer{cfg: cfg}
+}
+
+// LogMailer writes messages to the log instead of sending them
+type LogMailer struct{}
+
+func (m *LogMailer) Send(ctx context.Context, msg *Message) error {
+	log.Printf("MAIL to %s: %s\n%s", strings.Join(msg.To, ", "), msg.Subject, msg.Text)
+	return nil
+}
+
+type SMTPMailer struct {
+	cfg *Config
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Text, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+
+	// net/smtp has no context support, at least honour cancellation before
+	// we start talking to the server
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(m.cfg.Host, m.cfg.Port)
+	if err := smtp.SendMail(addr, auth, m.cfg.From, msg.To, []byte(b.String())); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Panic is a panic caught by Recover
+type Panic struct {
+	Value  interface{}
+	Stack  []byte
+	Frames []Frame // innermost first, starting where the panic happened
+}
+
+// Frame is a function call in a panicking goroutine's stack
+type Frame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+// Recover catches panics in the handlers it wraps, logs them with the stack
+// and request and hands them to handle to write the response. The server
+// already handles http.ErrAbortHandler quietly, so it's passed on.
+func Recover(handle func(w http.ResponseWriter, r *http.Request, p *Panic)) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+
+				p := &Panic{Value: v, Stack: debug.Stack(), Frames: panicFrames()}
+				log.Printf("panic: %v\n%s %s request %s\n%s", v, r.Method, r.URL.Path, GetRequestID(r), p.Stack)
+				handle(w, r, p)
+			}()
+
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// panicFrames returns the stack from where the panic happened, it must be
+// called from the deferred function that recovered
+func panicFrames() []Frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var all []Frame
+	start := 0
+	for {
+		f, more := frames.Next()
+		all = append(all, Frame{Function: f.Function, File: f.File, Line: f.Line})
+		if f.Function == "runtime.gopanic" {
+			start = len(all)
+		}
+		if !more {
+			break
+		}
+	}
+	return all[start:]
+}
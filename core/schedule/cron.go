@@ -0,0 +1,214 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// when is how often a task runs
+type when interface {
+	// next is the first time after t, zero if there isn't one
+	next(t time.Time) time.Time
+	String() string
+}
+
+// every runs a task on an interval, counted from when the last run finished
+type every time.Duration
+
+func (e every) next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+func (e every) String() string {
+	return "every " + time.Duration(e).String()
+}
+
+// cron is a five field cron expression: minute, hour, day of month, month
+// and day of week, in local time
+type cron struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64 // bit sets of the values that match
+	// a restricted day of month or week matches either, as in crontab
+	domAll, dowAll bool
+	// hourAll tasks run in both passes of the hour the clocks go back, others
+	// only in the first, as in crontab
+	hourAll bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// parseCron parses a crontab style expression such as "30 2 * * mon-fri",
+// "*/15 * * * *" or "@daily"
+func parseCron(spec string) (*cron, error) {
+	expr := strings.TrimSpace(spec)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q needs 5 fields, it has %d", spec, len(fields))
+	}
+
+	c := &cron{
+		spec:    spec,
+		domAll:  strings.HasPrefix(fields[2], "*"),
+		dowAll:  strings.HasPrefix(fields[4], "*"),
+		hourAll: strings.HasPrefix(fields[1], "*"),
+	}
+	var err error
+	if c.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("cron expression %q minute: %w", spec, err)
+	}
+	if c.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("cron expression %q hour: %w", spec, err)
+	}
+	if c.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("cron expression %q day of month: %w", spec, err)
+	}
+	if c.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("cron expression %q month: %w", spec, err)
+	}
+	// 7 is Sunday too
+	if c.dow, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("cron expression %q day of week: %w", spec, err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseField parses a comma separated list of *, values and ranges, each
+// with an optional /step
+func parseField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step %q", stepText)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = fieldValue(first, min, max, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = fieldValue(last, min, max, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "5/15" is from 5 to the end
+				hi = max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("range %q goes backwards", rng)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func fieldValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("%d is outside %d-%d", v, min, max)
+	}
+	return v, nil
+}
+
+func (c *cron) next(t time.Time) time.Time {
+	from := wallClock(t)
+	t = after(t, t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1)
+
+	// every expression matches within a few years, or never, e.g. Feb 30
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = after(t, t.Year(), t.Month()+1, 1, 0, 0)
+		case !c.dayMatches(t):
+			t = after(t, t.Year(), t.Month(), t.Day()+1, 0, 0)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = after(t, t.Year(), t.Month(), t.Day(), t.Hour()+1, 0)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		case !c.hourAll && !wallClock(t).After(from):
+			// the clocks went back and this time has been already
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// after returns the given local time, or when the clocks going forward
+// skip it the moment they do. time.Date moves a skipped time back to before
+// t, which would leave next going round in circles.
+func after(t time.Time, year int, month time.Month, day, hour, min int) time.Time {
+	next := time.Date(year, month, day, hour, min, 0, 0, t.Location())
+	for !next.After(t) {
+		next = next.Add(time.Hour)
+	}
+	return next
+}
+
+// wallClock is t's date and time of day without its zone, to compare times
+// as a clock shows them
+func wallClock(t time.Time) time.Time {
+	year, month, day := t.Date()
+	hour, min, sec := t.Clock()
+	return time.Date(year, month, day, hour, min, sec, t.Nanosecond(), time.UTC)
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAll || c.dowAll {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (c *cron) String() string {
+	return c.spec
+}
@@ -0,0 +1,190 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // for America/New_York's DST changes wherever the tests run
+)
+
+// span is the bit set of the values from lo to hi
+func span(lo, hi int) uint64 {
+	var b uint64
+	for v := lo; v <= hi; v++ {
+		b |= 1 << v
+	}
+	return b
+}
+
+// set is the bit set of the values
+func set(values ...int) uint64 {
+	var b uint64
+	for _, v := range values {
+		b |= 1 << v
+	}
+	return b
+}
+
+func TestParseCron(t *testing.T) {
+	var (
+		minutes = span(0, 59)
+		hours   = span(0, 23)
+		days    = span(1, 31)
+		months  = span(1, 12)
+		weekday = span(0, 7)
+	)
+
+	tests := []struct {
+		spec                          string
+		minute, hour, dom, month, dow uint64
+	}{
+		{"* * * * *", minutes, hours, days, months, weekday},
+		{"30 2 * * *", set(30), set(2), days, months, weekday},
+		{"*/15 * * * *", set(0, 15, 30, 45), hours, days, months, weekday},
+		{"5/20 * * * *", set(5, 25, 45), hours, days, months, weekday},
+		{"10-20/5 * * * *", set(10, 15, 20), hours, days, months, weekday},
+		{"0 9-17/4 * * *", set(0), set(9, 13, 17), days, months, weekday},
+		{"0 0 1,15 * *", set(0), set(0), set(1, 15), months, weekday},
+		{"0 0 * jan,Jul-sep *", set(0), set(0), days, set(1, 7, 8, 9), weekday},
+		{"0 0 * * mon-fri", set(0), set(0), days, months, span(1, 5)},
+		{"0 0 * * SUN", set(0), set(0), days, months, set(0)},
+		// 7 is Sunday too
+		{"0 0 * * 7", set(0), set(0), days, months, set(0, 7)},
+		{"0 0 * * 5-7", set(0), set(0), days, months, set(0, 5, 6, 7)},
+		{"@weekly", set(0), set(0), days, months, set(0)},
+		{"@HOURLY", set(0), hours, days, months, weekday},
+		{"  @monthly ", set(0), set(0), set(1), months, weekday},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.spec)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tt.spec, err)
+			continue
+		}
+		if c.minute != tt.minute || c.hour != tt.hour || c.dom != tt.dom || c.month != tt.month || c.dow != tt.dow {
+			t.Errorf("parseCron(%q) = %b %b %b %b %b, want %b %b %b %b %b", tt.spec,
+				c.minute, c.hour, c.dom, c.month, c.dow, tt.minute, tt.hour, tt.dom, tt.month, tt.dow)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@every 5m",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"20-10 * * * *",
+		"foo * * * *",
+		"* * * * mon-",
+		"1,,2 * * * *",
+		"-1 * * * *",
+	} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 1 January 2026 is a Thursday
+	date := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", date(1, 1, 10, 7).Add(30 * time.Second), date(1, 1, 10, 15)},
+		// strictly after
+		{"*/15 * * * *", date(1, 1, 10, 45), date(1, 1, 11, 0)},
+		{"30 2 * * *", date(1, 1, 2, 30), date(1, 2, 2, 30)},
+		{"@hourly", date(1, 1, 10, 30), date(1, 1, 11, 0)},
+		{"0 9 * * mon-fri", date(1, 2, 10, 0), date(1, 5, 9, 0)},
+		{"0 0 * * 7", date(1, 1, 0, 0), date(1, 4, 0, 0)},
+		{"0 0 * jan,jul *", date(1, 31, 23, 59), date(7, 1, 0, 0)},
+		{"0 0 31 * *", date(1, 31, 1, 0), date(3, 31, 0, 0)},
+		{"0 0 29 2 *", date(3, 1, 0, 0), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", date(1, 1, 0, 0), time.Time{}},
+
+		// a restricted day of month and day of week match either
+		{"0 0 13 * fri", date(1, 1, 0, 0), date(1, 2, 0, 0)},
+		{"0 0 13 * fri", date(1, 12, 1, 0), date(1, 13, 0, 0)},
+		// with either one * only the other counts
+		{"0 0 13 * *", date(1, 1, 0, 0), date(1, 13, 0, 0)},
+		{"0 0 * * fri", date(1, 1, 0, 0), date(1, 2, 0, 0)},
+		// a step over * still counts as *, so both have to match, the first
+		// Monday on the 1st, 11th, 21st or 31st is 11 May
+		{"0 0 */10 * mon", date(1, 1, 0, 0), date(5, 11, 0, 0)},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.spec, err)
+		}
+		if got := c.next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q after %v = %v, want %v", tt.spec, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, ny)
+	}
+	// 1:30 comes round twice on 1 November, time.Date gives the first
+	firstPass := local(11, 1, 1, 30)
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		// the clocks go from 2:00 to 3:00 on 8 March
+		{"skipped time", "30 2 * * *", local(3, 7, 12, 0), local(3, 9, 2, 30)},
+		{"hourly over the gap", "0 * * * *", local(3, 8, 1, 30), local(3, 8, 1, 30).Add(30 * time.Minute)},
+		{"just after the gap", "0 3 * * *", local(3, 8, 0, 0), local(3, 8, 3, 0)},
+
+		// and from 2:00 back to 1:00 on 1 November
+		{"before the repeat", "30 1 * * *", local(11, 1, 0, 0), firstPass},
+		{"not twice a night", "30 1 * * *", firstPass.Add(5 * time.Second), local(11, 2, 1, 30)},
+		// tasks run every hour carry on through it, 1:00 the second time is
+		// half an hour after the first 1:30
+		{"hourly through the repeat", "0 * * * *", firstPass, firstPass.Add(30 * time.Minute)},
+		{"every 15 minutes through the repeat", "*/15 * * * *", firstPass.Add(20 * time.Minute), firstPass.Add(30 * time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.spec)
+			if err != nil {
+				t.Fatalf("parseCron(%q): %v", tt.spec, err)
+			}
+
+			// a time the clocks skip used to leave next going round in circles
+			done := make(chan time.Time, 1)
+			go func() { done <- c.next(tt.from) }()
+			select {
+			case got := <-done:
+				if !got.Equal(tt.want) {
+					t.Errorf("%q after %v = %v, want %v", tt.spec, tt.from, got, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%q after %v never returned", tt.spec, tt.from)
+			}
+		})
+	}
+}
@@ -0,0 +1,429 @@
+// Package schedule runs recurring tasks, like nightly cleanups or digests,
+// on cron expressions or intervals. A task never overlaps itself, and each
+// run is recorded in SQLite so the admin can see how they went.
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	ErrNotFound = errors.New("task not found")
+	// ErrRunning is returned by RunNow while the task is already running
+	ErrRunning = errors.New("task is already running")
+	ErrStopped = errors.New("scheduler is stopped")
+)
+
+// Run statuses
+const (
+	StatusRunning = "running"
+	StatusOK      = "ok"
+	StatusFailed  = "failed"
+)
+
+// historyPerTask is how many runs are kept for each task
+const historyPerTask = 100
+
+// Task is the work done on each run. ctx is cancelled when the app shuts
+// down, returning an error or panicking marks the run failed.
+type Task func(ctx context.Context) error
+
+// Options change when a task runs, nil for none
+type Options struct {
+	// Jitter delays each run by a random amount up to this long, so tasks
+	// on the same schedule, or several servers, don't all start at once
+	Jitter time.Duration
+}
+
+// Run is one run of a task
+type Run struct {
+	ID         int64
+	Task       string
+	Status     string
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time // zero while it's running
+}
+
+// Duration is how long the run took, zero while it's running
+func (r *Run) Duration() time.Duration {
+	if r.FinishedAt.IsZero() {
+		return 0
+	}
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// TaskInfo is a registered task and how it's getting on
+type TaskInfo struct {
+	Name     string
+	Schedule string
+	Jitter   time.Duration
+	Next     time.Time // zero until the scheduler starts
+	Running  bool
+	Last     *Run // nil if it has never run
+}
+
+type task struct {
+	name   string
+	when   when
+	jitter time.Duration
+	fn     Task
+
+	lock    sync.Mutex // held while it runs, so runs can't overlap
+	next    time.Time  // guarded by the scheduler's mu
+	running bool       // guarded by the scheduler's mu
+}
+
+// Scheduler runs the registered tasks from Start until Stop
+type Scheduler struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	tasks   []*task // in the order they were registered
+	ctx     context.Context
+	stop    context.CancelFunc
+	started bool
+	stopped bool
+	running sync.WaitGroup // the task loops and runs started by RunNow
+}
+
+// New opens the run history at path, creating it if needed
+func New(path string) (*Scheduler, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	migrations := []string{
+		`CREATE TABLE IF NOT EXISTS runs (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            task TEXT NOT NULL,
+            status TEXT NOT NULL,
+            error TEXT NOT NULL DEFAULT '',
+            started_at DATETIME NOT NULL,
+            finished_at DATETIME
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_runs_task ON runs(task, id);`,
+	}
+	for _, m := range migrations {
+		if _, err := db.Exec(m); err != nil {
+			return nil, fmt.Errorf("failed to run migrations: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{db: db, ctx: ctx, stop: cancel}, nil
+}
+
+func (s *Scheduler) Close() error {
+	return s.db.Close()
+}
+
+// Cron runs task on a crontab style schedule in local time, such as
+// "30 2 * * *" for 2:30 every night or "@hourly". Names are app wide so
+// prefix them with the feature, e.g. "users.cleanup".
+func (s *Scheduler) Cron(name, spec string, task Task, opts *Options) error {
+	c, err := parseCron(spec)
+	if err != nil {
+		return err
+	}
+	if c.next(time.Now()).IsZero() {
+		return fmt.Errorf("cron expression %q never matches", spec)
+	}
+	return s.add(name, c, task, opts)
+}
+
+// Every runs task repeatedly, waiting interval after each run finishes
+func (s *Scheduler) Every(name string, interval time.Duration, task Task, opts *Options) error {
+	if interval <= 0 {
+		return fmt.Errorf("task %s needs an interval above 0", name)
+	}
+	return s.add(name, every(interval), task, opts)
+}
+
+func (s *Scheduler) add(name string, w when, fn Task, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.find(name) != nil {
+		return fmt.Errorf("task %s registered twice", name)
+	}
+	t := &task{name: name, when: w, jitter: opts.Jitter, fn: fn}
+	s.tasks = append(s.tasks, t)
+
+	// tasks added once it's running start straight away
+	if s.started {
+		s.running.Add(1)
+		go s.loop(t)
+	}
+	return nil
+}
+
+// find returns the task called name, s.mu must be held
+func (s *Scheduler) find(name string) *task {
+	for _, t := range s.tasks {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// Start runs the tasks until Stop. Runs left unfinished when the app last
+// stopped are marked failed.
+func (s *Scheduler) Start() error {
+	_, err := s.db.Exec(
+		`UPDATE runs SET status = ?, error = ?, finished_at = ? WHERE status = ?`,
+		StatusFailed, "interrupted", time.Now(), StatusRunning,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to tidy up interrupted runs: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = true
+	for _, t := range s.tasks {
+		s.running.Add(1)
+		go s.loop(t)
+	}
+	if len(s.tasks) > 0 {
+		log.Printf("Started %d scheduled tasks", len(s.tasks))
+	}
+	return nil
+}
+
+// Stop cancels the running tasks and waits for them to return, until ctx
+// is done
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	s.stop()
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for scheduled tasks: %w", ctx.Err())
+	}
+}
+
+// loop runs the task each time it's due until the scheduler stops
+func (s *Scheduler) loop(t *task) {
+	defer s.running.Done()
+
+	for {
+		next := t.when.next(time.Now())
+		if next.IsZero() {
+			log.Printf("Task %s has no more runs due", t.name)
+			return
+		}
+		if t.jitter > 0 {
+			next = next.Add(rand.N(t.jitter))
+		}
+		s.mu.Lock()
+		t.next = next
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !t.lock.TryLock() {
+			log.Printf("Task %s is still running, skipping the run due at %s", t.name, next.Format(time.DateTime))
+			continue
+		}
+		s.execute(t)
+		t.lock.Unlock()
+	}
+}
+
+// RunNow starts a run of the task straight away, unless it's running
+func (s *Scheduler) RunNow(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return ErrStopped
+	}
+	t := s.find(name)
+	if t == nil {
+		return ErrNotFound
+	}
+	if !t.lock.TryLock() {
+		return ErrRunning
+	}
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer t.lock.Unlock()
+		s.execute(t)
+	}()
+	return nil
+}
+
+// execute runs the task, recording the run in the history. The task's
+// lock must be held.
+func (s *Scheduler) execute(t *task) {
+	s.mu.Lock()
+	t.running = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		t.running = false
+		s.mu.Unlock()
+	}()
+
+	result, err := s.db.Exec(
+		`INSERT INTO runs (task, status, started_at) VALUES (?, ?, ?)`,
+		t.name, StatusRunning, time.Now(),
+	)
+	var id int64
+	if err == nil {
+		id, err = result.LastInsertId()
+	}
+	if err != nil {
+		// the history is only for information, run it anyway
+		log.Printf("Error recording run of task %s: %v", t.name, err)
+	}
+
+	status, message := StatusOK, ""
+	if err := call(s.ctx, t); err != nil {
+		log.Printf("Task %s failed: %v", t.name, err)
+		status, message = StatusFailed, err.Error()
+	}
+
+	if id == 0 {
+		return
+	}
+	_, err = s.db.Exec(
+		`UPDATE runs SET status = ?, error = ?, finished_at = ? WHERE id = ?`,
+		status, message, time.Now(), id,
+	)
+	if err == nil {
+		_, err = s.db.Exec(
+			`DELETE FROM runs WHERE task = ? AND id NOT IN (
+                SELECT id FROM runs WHERE task = ? ORDER BY id DESC LIMIT ?)`,
+			t.name, t.name, historyPerTask,
+		)
+	}
+	if err != nil {
+		log.Printf("Error recording run of task %s: %v", t.name, err)
+	}
+}
+
+// call runs the task, turning a panic into an error
+func call(ctx context.Context, t *task) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("panic in task %s: %v\n%s", t.name, v, debug.Stack())
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return t.fn(ctx)
+}
+
+// Tasks returns the registered tasks, in the order they were registered
+func (s *Scheduler) Tasks() ([]TaskInfo, error) {
+	s.mu.Lock()
+	infos := make([]TaskInfo, len(s.tasks))
+	for i, t := range s.tasks {
+		infos[i] = TaskInfo{
+			Name:     t.name,
+			Schedule: t.when.String(),
+			Jitter:   t.jitter,
+			Next:     t.next,
+			Running:  t.running,
+		}
+	}
+	s.mu.Unlock()
+
+	for i := range infos {
+		last, err := scanRun(s.db.QueryRow(
+			`SELECT `+runColumns+` FROM runs WHERE task = ? ORDER BY id DESC LIMIT 1`,
+			infos[i].Name,
+		))
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos[i].Last = last
+	}
+	return infos, nil
+}
+
+const runColumns = `id, task, status, error, started_at, finished_at`
+
+// History returns the latest runs, newest first, of the task or of every
+// task when it's empty
+func (s *Scheduler) History(task string, limit int) ([]*Run, error) {
+	query := `SELECT ` + runColumns + ` FROM runs`
+	var args []interface{}
+	if task != "" {
+		query += ` WHERE task = ?`
+		args = append(args, task)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []*Run
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+func scanRun(row interface{ Scan(...any) error }) (*Run, error) {
+	var r Run
+	var finished sql.NullTime
+	if err := row.Scan(&r.ID, &r.Task, &r.Status, &r.Error, &r.StartedAt, &finished); err != nil {
+		return nil, err
+	}
+	r.FinishedAt = finished.Time
+	return &r, nil
+}
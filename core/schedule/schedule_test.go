@@ -0,0 +1,133 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestScheduler(t *testing.T) *Scheduler {
+	t.Helper()
+	s, err := New(filepath.Join(t.TempDir(), "schedule.db"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Stop(ctx)
+		s.Close()
+	})
+	return s
+}
+
+// waitFor polls until ok is true, failing the test after a few seconds
+func waitFor(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if ok() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestCronNeverMatches(t *testing.T) {
+	s := newTestScheduler(t)
+	noop := func(ctx context.Context) error { return nil }
+
+	if err := s.Cron("test.never", "0 0 30 2 *", noop, nil); err == nil {
+		t.Error("Cron accepted an expression that never matches")
+	}
+	if err := s.Cron("test.bad", "0 0 * *", noop, nil); err == nil {
+		t.Error("Cron accepted a bad expression")
+	}
+	if err := s.Cron("test.daily", "@daily", noop, nil); err != nil {
+		t.Fatalf("Cron: %v", err)
+	}
+	if err := s.Every("test.daily", time.Hour, noop, nil); err == nil {
+		t.Error("a task was registered twice")
+	}
+}
+
+// A task never overlaps itself, whether it's due or run by hand
+func TestNoOverlap(t *testing.T) {
+	s := newTestScheduler(t)
+
+	var runs atomic.Int32
+	release := make(chan struct{})
+	err := s.Every("test.slow", 10*time.Millisecond, func(ctx context.Context) error {
+		runs.Add(1)
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("Every: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	waitFor(t, "the first run", func() bool { return runs.Load() == 1 })
+
+	if err := s.RunNow("test.slow"); err != ErrRunning {
+		t.Errorf("RunNow while it's running: %v, want ErrRunning", err)
+	}
+	if err := s.RunNow("test.missing"); err != ErrNotFound {
+		t.Errorf("RunNow of an unknown task: %v, want ErrNotFound", err)
+	}
+	tasks, err := s.Tasks()
+	if err != nil || len(tasks) != 1 || !tasks[0].Running {
+		t.Fatalf("Tasks() = %+v, %v, want test.slow running", tasks, err)
+	}
+
+	// its interval passes several times over but it isn't started again
+	time.Sleep(50 * time.Millisecond)
+	if n := runs.Load(); n != 1 {
+		t.Fatalf("%d runs while the first was still going", n)
+	}
+	close(release)
+	waitFor(t, "the runs to be recorded", func() bool {
+		history, _ := s.History("test.slow", 10)
+		return len(history) >= 2 && history[len(history)-1].Status == StatusOK
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if err := s.RunNow("test.slow"); err != ErrStopped {
+		t.Errorf("RunNow after Stop: %v, want ErrStopped", err)
+	}
+}
+
+func TestRunNowRecordsFailures(t *testing.T) {
+	s := newTestScheduler(t)
+	s.Every("test.fail", time.Hour, func(ctx context.Context) error {
+		return errors.New("no luck")
+	}, nil)
+	s.Every("test.panic", time.Hour, func(ctx context.Context) error {
+		panic("boom")
+	}, nil)
+
+	for task, want := range map[string]string{"test.fail": "no luck", "test.panic": "panic: boom"} {
+		if err := s.RunNow(task); err != nil {
+			t.Fatalf("RunNow(%s): %v", task, err)
+		}
+		waitFor(t, task+" to finish", func() bool {
+			history, _ := s.History(task, 1)
+			return len(history) == 1 && history[0].Status != StatusRunning
+		})
+		history, _ := s.History(task, 1)
+		if run := history[0]; run.Status != StatusFailed || run.Error != want || run.Duration() < 0 {
+			t.Errorf("%s run = %s %q, want failed %q", task, run.Status, run.Error, want)
+		}
+	}
+}
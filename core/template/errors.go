@@ -0,0 +1,146 @@
+package template
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/MickDuprez/gobase/core/interfaces"
+)
+
+//go:embed errors/*.html
+var builtinErrors embed.FS
+
+// The built in pages are used when there's no error page for a status, or
+// it fails, so they don't depend on the layout or any helpers
+var (
+	builtinError    = template.Must(template.ParseFS(builtinErrors, "errors/error.html"))
+	builtinFragment = template.Must(template.ParseFS(builtinErrors, "errors/fragment.html"))
+)
+
+// errorDefaults are the blocks of the base layout an error page doesn't
+// have to define, it only needs "title" and "content"
+const errorDefaults = `{{define "head"}}{{end}}{{define "scripts"}}{{end}}{{define "layout"}}{{template "content" .}}{{end}}`
+
+// ErrorPage is the data error templates get as .Data
+type ErrorPage struct {
+	Status    int
+	Title     string // the status text, e.g. "Not Found"
+	Message   string
+	RequestID string
+}
+
+// parseErrorPages parses the templates/errors/<status>.html pages in fsys
+// with the base layout, and templates/layout.html when there is one
+func (m *Manager) parseErrorPages(fsys fs.FS, funcs template.FuncMap) (map[int]*template.Template, error) {
+	pages, err := fs.Glob(fsys, "templates/errors/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find error pages: %w", err)
+	}
+
+	_, err = fs.Stat(fsys, "templates/layout.html")
+	layout := err == nil
+
+	parsed := make(map[int]*template.Template, len(pages))
+	for _, page := range pages {
+		status, err := strconv.Atoi(strings.TrimSuffix(path.Base(page), ".html"))
+		if err != nil {
+			log.Printf("Skipping error page %s, it isn't named after a status code", page)
+			continue
+		}
+
+		ts, err := template.New("base").Funcs(m.helperFuncs).Funcs(funcs).Parse(errorDefaults)
+		if err != nil {
+			return nil, err
+		}
+		if ts, err = ts.ParseFiles("templates/layouts/base.html"); err != nil {
+			return nil, fmt.Errorf("failed to parse base template: %w", err)
+		}
+		if layout {
+			if ts, err = ts.ParseFS(fsys, "templates/layout.html"); err != nil {
+				return nil, fmt.Errorf("failed to parse layout template: %w", err)
+			}
+		}
+		if ts, err = ts.ParseFS(fsys, page); err != nil {
+			return nil, fmt.Errorf("failed to parse error page %s: %w", page, err)
+		}
+		parsed[status] = ts
+	}
+	return parsed, nil
+}
+
+// loadErrorPages parses the app's own error pages, done on the first error so
+// that helper funcs registered during startup are available to them
+func (m *Manager) loadErrorPages() {
+	// app wide pages aren't in a feature, the url helper resolves from the root
+	funcs := template.FuncMap{
+		"url": func(target string, params ...interface{}) (string, error) {
+			return m.url(interfaces.Feature{}, target, params...)
+		},
+	}
+
+	pages, err := m.parseErrorPages(os.DirFS("."), funcs)
+	if err != nil {
+		log.Printf("Error loading error pages: %v", err)
+	}
+	m.errorPages = pages
+}
+
+// errorPage finds the page for status, the feature's own before the app's
+func (m *Manager) errorPage(feature string, status int) (*template.Template, bool) {
+	m.errorPagesOnce.Do(m.loadErrorPages)
+
+	if ts, ok := m.featureErrorPages[feature][status]; ok {
+		return ts, true
+	}
+	ts, ok := m.errorPages[status]
+	return ts, ok
+}
+
+// RenderError writes an error response page for the feature, falling back
+// from its templates/errors/<status>.html to the app's and then to a plain
+// built in page
+func (m *Manager) RenderError(w http.ResponseWriter, r *http.Request, feature string, page ErrorPage) error {
+	return m.renderError(w, r, feature, page, "base", builtinError)
+}
+
+// RenderErrorFragment is RenderError for htmx requests, only the "content"
+// block of the error page is written so it can be swapped into the page
+func (m *Manager) RenderErrorFragment(w http.ResponseWriter, r *http.Request, feature string, page ErrorPage) error {
+	return m.renderError(w, r, feature, page, "content", builtinFragment)
+}
+
+func (m *Manager) renderError(w http.ResponseWriter, r *http.Request, feature string, page ErrorPage, name string, builtin *template.Template) error {
+	if page.Title == "" {
+		page.Title = http.StatusText(page.Status)
+	}
+
+	buf := new(bytes.Buffer)
+	rendered := false
+	if ts, ok := m.errorPage(feature, page.Status); ok {
+		if err := ts.ExecuteTemplate(buf, name, m.viewData(r, feature, page)); err != nil {
+			log.Printf("Error executing error page %d: %v", page.Status, err)
+			buf.Reset()
+		} else {
+			rendered = true
+		}
+	}
+	if !rendered {
+		if err := builtin.Execute(buf, struct{ Data ErrorPage }{page}); err != nil {
+			return fmt.Errorf("failed to render error page: %w", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(page.Status)
+	buf.WriteTo(w)
+	return nil
+}
@@ -2,13 +2,16 @@ package template
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"path"
 	"strings"
+	"sync"
 
 	"github.com/MickDuprez/gobase/core/interfaces"
 )
@@ -18,14 +21,22 @@ type Manager struct {
 	partialTemplates map[string]*template.Template
 	navItems         []interfaces.NavItem
 	helperFuncs      template.FuncMap
+	layoutData       map[string]func(r *http.Request) interface{}
+	routeURL         func(name string, params ...interface{}) (string, error)
+	errorPages       map[int]*template.Template
+	errorPagesOnce   sync.Once
+	// featureErrorPages are the error pages features have of their own
+	featureErrorPages map[string]map[int]*template.Template
 }
 
 func New() (*Manager, error) {
 	return &Manager{
-		pageTemplates:    make(map[string]*template.Template),
-		partialTemplates: make(map[string]*template.Template),
-		navItems:         make([]interfaces.NavItem, 0),
-		helperFuncs:      make(template.FuncMap),
+		pageTemplates:     make(map[string]*template.Template),
+		partialTemplates:  make(map[string]*template.Template),
+		navItems:          make([]interfaces.NavItem, 0),
+		helperFuncs:       make(template.FuncMap),
+		layoutData:        make(map[string]func(r *http.Request) interface{}),
+		featureErrorPages: make(map[string]map[int]*template.Template),
 	}, nil
 }
 
@@ -33,23 +44,82 @@ func (m *Manager) RegisterHelperFunc(name string, fn interface{}) {
 	m.helperFuncs[name] = fn
 }
 
-func (m *Manager) RegisterFeature(name, path string, navItems ...interfaces.NavItem) error {
+// SetRouteResolver sets how the url helper turns route names into paths
+func (m *Manager) SetRouteResolver(fn func(name string, params ...interface{}) (string, error)) {
+	m.routeURL = fn
+}
+
+// url is the url template helper. Paths starting with "/" are relative to
+// the feature's mount, anything else is the name of a route:
+//
+//	{{url "/team"}}                      within the feature
+//	{{url "users.tokens.revoke" .ID}}    a named route, filling in {id}
+func (m *Manager) url(f interfaces.Feature, target string, params ...interface{}) (string, error) {
+	if strings.HasPrefix(target, "/") {
+		return f.URL(target), nil
+	}
+	if m.routeURL == nil {
+		return "", fmt.Errorf("no route resolver for %q", target)
+	}
+	return m.routeURL(target, params...)
+}
+
+// RegisterLayoutData adds a value computed for every page, available to the
+// base layout as .Layout.<name>
+func (m *Manager) RegisterLayoutData(name string, fn func(r *http.Request) interface{}) {
+	m.layoutData[name] = fn
+}
+
+// RegisterFeature parses the feature's templates, from f.FS when it's set
+// (such as an embed.FS for features that ship inside a package) or else from
+// the f.Path directory. The layout is always the app's
+// templates/layouts/base.html. Nav URLs and the url template helper are
+// resolved against the feature's mount.
+func (m *Manager) RegisterFeature(f interfaces.Feature) error {
+	name := f.Name
+	fsys := f.FS
+	if fsys == nil {
+		fsys = os.DirFS(f.Path)
+	}
+	// helpers that depend on the feature, on top of the app wide ones
+	featureFuncs := template.FuncMap{
+		"url": func(target string, params ...interface{}) (string, error) {
+			return m.url(f, target, params...)
+		},
+	}
+
 	// Get all page templates for this feature
-	pages, err := filepath.Glob(filepath.Join(path, "templates", "*.html"))
+	pages, err := fs.Glob(fsys, "templates/*.html")
 	if err != nil {
 		return fmt.Errorf("failed to find feature templates: %w", err)
 	}
 
+	// Find any partials for HTMX etc.
+	var partials []string
+	if _, err := fs.Stat(fsys, "templates/partials"); errors.Is(err, fs.ErrNotExist) {
+		// Directory doesn't exist
+		log.Printf("No partials directory for feature %s", name)
+	} else {
+		// Directory exists, check for files
+		partials, err = fs.Glob(fsys, "templates/partials/*.html")
+		if err != nil {
+			return fmt.Errorf("error checking for partials: %w", err)
+		}
+		if len(partials) == 0 {
+			log.Printf("Partials directory exists but no .html files found for feature %s", name)
+		}
+	}
+
 	log.Printf("Registering feature %s:", name)
 	for _, page := range pages {
-		pageName := filepath.Base(page)
+		pageName := path.Base(page)
 		if pageName == "layout.html" {
 			continue // Skip layout file as it's handled separately
 		}
 		log.Printf("  Processing: %s", pageName)
 
 		// create template and add helperFuncs
-		ts := template.New("base").Funcs(m.helperFuncs)
+		ts := template.New("base").Funcs(m.helperFuncs).Funcs(featureFuncs)
 
 		// Start with base template
 		ts, err = ts.ParseFiles("templates/layouts/base.html")
@@ -58,56 +128,72 @@ func (m *Manager) RegisterFeature(name, path string, navItems ...interfaces.NavI
 		}
 
 		// Add feature layout template
-		layoutPath := filepath.Join(path, "templates", "layout.html")
-		ts, err = ts.ParseFiles(layoutPath)
+		ts, err = ts.ParseFS(fsys, "templates/layout.html")
 		if err != nil {
 			return fmt.Errorf("failed to parse layout template: %w", err)
 		}
 
 		// Finally add the page template
-		ts, err = ts.ParseFiles(page)
+		ts, err = ts.ParseFS(fsys, page)
 		if err != nil {
 			return fmt.Errorf("failed to parse page template: %w", err)
 		}
 
+		// Pages can include the partials, so full pages and HTMX swaps
+		// share the same markup
+		if len(partials) > 0 {
+			ts, err = ts.ParseFS(fsys, partials...)
+			if err != nil {
+				return fmt.Errorf("failed to parse partial templates: %w", err)
+			}
+		}
+
 		// Store in cache with feature-prefixed name
 		cacheKey := fmt.Sprintf("%s_%s", name, strings.TrimSuffix(pageName, ".html"))
 		m.pageTemplates[cacheKey] = ts
 		log.Printf("  Cached as: %s", cacheKey)
 	}
 
-	// Register any partials for HTMX etc.
-	partialsPath := filepath.Join(path, "templates", "partials")
-	if _, err := os.Stat(partialsPath); os.IsNotExist(err) {
-		// Directory doesn't exist
-		log.Printf("No partials directory for feature %s", name)
-	} else {
-		// Directory exists, check for files
-		partials, err := filepath.Glob(filepath.Join(partialsPath, "*.html"))
+	if len(partials) > 0 {
+		// Parse all partials for this feature with helper funcs
+		ts := template.New("partials").Funcs(m.helperFuncs).Funcs(featureFuncs)
+		ts, err := ts.ParseFS(fsys, partials...)
 		if err != nil {
-			return fmt.Errorf("error checking for partials: %w", err)
+			return fmt.Errorf("failed to parse partial templates: %w", err)
 		}
+		m.partialTemplates[name] = ts
+		log.Printf("  Cached %d partials for feature: %s", len(partials), name)
+	}
 
-		if len(partials) == 0 {
-			log.Printf("Partials directory exists but no .html files found for feature %s", name)
-		} else {
-			// Parse all partials for this feature with helper funcs
-			ts := template.New("partials").Funcs(m.helperFuncs)
-			ts, err := ts.ParseFiles(partials...)
-			if err != nil {
-				return fmt.Errorf("failed to parse partial templates: %w", err)
-			}
-			m.partialTemplates[name] = ts
-			log.Printf("  Cached %d partials for feature: %s", len(partials), name)
-		}
+	// Error pages the feature shows instead of the app's
+	errorPages, err := m.parseErrorPages(fsys, featureFuncs)
+	if err != nil {
+		return err
+	}
+	if len(errorPages) > 0 {
+		m.featureErrorPages[name] = errorPages
+		log.Printf("  Cached %d error pages for feature: %s", len(errorPages), name)
 	}
 
 	// Store nav items
-	m.navItems = append(m.navItems, navItems...)
+	m.navItems = append(m.navItems, mountNavItems(f, f.NavItems)...)
 
 	return nil
 }
 
+// mountNavItems resolves the items' URLs against the feature's mount
+func mountNavItems(f interfaces.Feature, items []interfaces.NavItem) []interfaces.NavItem {
+	mounted := make([]interfaces.NavItem, len(items))
+	for i, item := range items {
+		if item.URL != "" {
+			item.URL = f.URL(item.URL)
+		}
+		item.SubItems = mountNavItems(f, item.SubItems)
+		mounted[i] = item
+	}
+	return mounted
+}
+
 func (m *Manager) Render(w http.ResponseWriter, r *http.Request, feature, page string, data interface{}) error {
 	templateName := fmt.Sprintf("%s_%s", feature, page)
 	log.Printf("Looking for template: %s", templateName)
@@ -117,21 +203,9 @@ func (m *Manager) Render(w http.ResponseWriter, r *http.Request, feature, page s
 		return fmt.Errorf("template %s not found", templateName)
 	}
 
-	viewData := struct {
-		Data     interface{}
-		NavItems []interfaces.NavItem
-		Feature  string
-		Error    string
-	}{
-		Data:     data,
-		NavItems: m.navItems,
-		Feature:  feature,
-		Error:    r.URL.Query().Get("error"),
-	}
-
 	// Use buffer for atomic writes
 	buf := new(bytes.Buffer)
-	if err := ts.ExecuteTemplate(buf, "base", viewData); err != nil {
+	if err := ts.ExecuteTemplate(buf, "base", m.viewData(r, feature, data)); err != nil {
 		log.Printf("Error executing template: %v", err)
 		return err
 	}
@@ -140,6 +214,52 @@ func (m *Manager) Render(w http.ResponseWriter, r *http.Request, feature, page s
 	return nil
 }
 
+// pageData is what the base layout is executed with
+type pageData struct {
+	Data     interface{}
+	NavItems []interfaces.NavItem
+	Feature  string
+	Error    string
+	Layout   map[string]interface{}
+
+	request *http.Request
+}
+
+// partialData is what partials are executed with
+type partialData struct {
+	Data interface{}
+
+	request *http.Request
+}
+
+// Request returns the request a page or partial is being rendered for, so
+// helpers that depend on it can be passed the page: {{flag $ "name"}}
+func Request(page interface{}) *http.Request {
+	switch p := page.(type) {
+	case pageData:
+		return p.request
+	case partialData:
+		return p.request
+	}
+	return nil
+}
+
+func (m *Manager) viewData(r *http.Request, feature string, data interface{}) pageData {
+	layout := make(map[string]interface{}, len(m.layoutData))
+	for name, fn := range m.layoutData {
+		layout[name] = fn(r)
+	}
+
+	return pageData{
+		Data:     data,
+		NavItems: m.navItems,
+		Feature:  feature,
+		Error:    r.URL.Query().Get("error"),
+		Layout:   layout,
+		request:  r,
+	}
+}
+
 func (m *Manager) RenderPartial(w http.ResponseWriter, r *http.Request, feature string, partial string, data interface{}) error {
 	log.Printf("RenderPartial: feature=%s, partial=%s", feature, partial)
 
@@ -149,11 +269,7 @@ func (m *Manager) RenderPartial(w http.ResponseWriter, r *http.Request, feature
 		return fmt.Errorf("feature %s not found", feature)
 	}
 
-	viewData := struct {
-		Data interface{}
-	}{
-		Data: data,
-	}
+	viewData := partialData{Data: data, request: r}
 
 	// Use buffer for atomic writes
 	buf := new(bytes.Buffer)
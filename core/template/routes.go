@@ -0,0 +1,131 @@
+package template
+
+import (
+	"html/template"
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+// RouteReference is a call to the url helper with a route name
+type RouteReference struct {
+	Template string
+	Name     string
+	Params   int // -1 when it can't be told from the template
+}
+
+// RouteReferences finds every url helper call naming a route in the parsed
+// templates, so the app can check the routes exist before serving anything
+func (m *Manager) RouteReferences() []RouteReference {
+	seen := make(map[RouteReference]bool)
+
+	// partials are parsed into every page of their feature, naming the
+	// reference after its file reports them once
+	collect := func(feature string, ts *template.Template) {
+		for _, t := range ts.Templates() {
+			if t.Tree == nil {
+				continue
+			}
+			for _, ref := range findRouteRefs(t.Tree.Root) {
+				ref.Template = feature + "/" + t.Tree.ParseName
+				seen[ref] = true
+			}
+		}
+	}
+	for key, ts := range m.pageTemplates {
+		collect(strings.SplitN(key, "_", 2)[0], ts)
+	}
+	for key, ts := range m.partialTemplates {
+		collect(key, ts)
+	}
+	for key, pages := range m.featureErrorPages {
+		for _, ts := range pages {
+			collect(key, ts)
+		}
+	}
+	m.errorPagesOnce.Do(m.loadErrorPages)
+	for _, ts := range m.errorPages {
+		collect("app", ts)
+	}
+
+	refs := make([]RouteReference, 0, len(seen))
+	for ref := range seen {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Template != refs[j].Template {
+			return refs[i].Template < refs[j].Template
+		}
+		return refs[i].Name < refs[j].Name
+	})
+	return refs
+}
+
+func findRouteRefs(node parse.Node) []RouteReference {
+	var refs []RouteReference
+
+	var walk func(n parse.Node)
+	walk = func(n parse.Node) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for i, cmd := range n.Cmds {
+				if ref, ok := routeRef(cmd, i > 0); ok {
+					refs = append(refs, ref)
+				}
+				for _, arg := range cmd.Args {
+					walk(arg)
+				}
+			}
+		}
+	}
+	walk(node)
+	return refs
+}
+
+// routeRef recognises {{url "name" args...}}, piped says a value is piped
+// in as the last param
+func routeRef(cmd *parse.CommandNode, piped bool) (RouteReference, bool) {
+	if len(cmd.Args) < 2 {
+		return RouteReference{}, false
+	}
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok || ident.Ident != "url" {
+		return RouteReference{}, false
+	}
+	name, ok := cmd.Args[1].(*parse.StringNode)
+	if !ok || strings.HasPrefix(name.Text, "/") {
+		return RouteReference{}, false
+	}
+
+	params := len(cmd.Args) - 2
+	if piped {
+		params++
+	}
+	return RouteReference{Name: name.Text, Params: params}, true
+}
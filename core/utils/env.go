@@ -54,7 +54,8 @@ func GetEnvStr(key, fallback string) string {
 	return fallback
 }
 
-// GetEnvBool returns environment variable as boolean or fallback if not found
+// GetEnvBool returns environment variable as boolean or fallback if not found,
+// it reads the OS environment only, not the .env file
 func GetEnvBool(key string, fallback bool) bool {
 	if strValue, exists := os.LookupEnv(key); exists {
 		if value, err := strconv.ParseBool(strValue); err == nil {
@@ -64,7 +65,8 @@ func GetEnvBool(key string, fallback bool) bool {
 	return fallback
 }
 
-// GetEnvInt returns environment variable as integer or fallback if not found
+// GetEnvInt returns environment variable as integer or fallback if not found,
+// it reads the OS environment only, not the .env file
 func GetEnvInt(key string, fallback int) int {
 	if strValue, exists := os.LookupEnv(key); exists {
 		if value, err := strconv.Atoi(strValue); err == nil {
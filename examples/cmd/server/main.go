@@ -1,13 +1,15 @@
 package main
 
 import (
+	"context"
 	"log"
-	"net/http"
 	"os"
 	"strings"
 
 	"github.com/MickDuprez/gobase/core/app"
+	"github.com/MickDuprez/gobase/core/auth/oidc"
 	"github.com/MickDuprez/gobase/core/config"
+	"github.com/MickDuprez/gobase/core/features/admin"
 	"github.com/MickDuprez/gobase/core/utils"
 	"github.com/MickDuprez/gobase/examples/features/about"
 	"github.com/MickDuprez/gobase/examples/features/home"
@@ -38,23 +40,37 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// "Sign in with..." providers configured in .env
+	sso := oidc.New(app.Auth(), oidc.NewConfigsFromEnv()...)
+
 	// register helper functions before we add any features
 	app.RegisterHelperFunc("split", split)
+	app.RegisterHelperFunc("oidcProviders", sso.Providers)
 
 	// Register features
 	if err := app.RegisterFeature(home.New()); err != nil {
 		log.Fatal(err)
 	}
+	// features can be mounted somewhere else, e.g. about.New().MountAt("/company")
 	if err := app.RegisterFeature(about.New()); err != nil {
 		log.Fatal(err)
 	}
 	if err := app.RegisterFeature(users.New()); err != nil {
 		log.Fatal(err)
 	}
+	if err := app.RegisterFeature(admin.New()); err != nil {
+		log.Fatal(err)
+	}
+	sso.Routes(app)
+
+	// Set the features up in dependency order, catching missing dependencies
+	// and templates linking to routes that don't exist
+	if err := app.Init(); err != nil {
+		log.Fatal(err)
+	}
 
-	// Start server
-	log.Printf("Server starting on %s", cfg.Server.Port)
-	if err := http.ListenAndServe(cfg.Server.Port, app); err != nil {
+	// Serve until interrupted, then shut down cleanly
+	if err := app.Run(context.Background()); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -1,30 +1,63 @@
 package about
 
-import "github.com/MickDuprez/gobase/core/interfaces"
+import (
+	"errors"
+
+	"github.com/MickDuprez/gobase/core/interfaces"
+)
+
+// Settings can be changed per deployment in the features config
+type Settings struct {
+	Team []TeamMember `json:"team"`
+}
+
+type TeamMember struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
 
 func New() interfaces.Feature {
+	settings := &Settings{
+		Team: []TeamMember{
+			{"John Doe", "Lead Developer"},
+			{"Jane Smith", "Designer"},
+		},
+	}
+
 	return interfaces.Feature{
-		Name: "about",
-		Path: "features/about",
+		Name:     "about",
+		Path:     "features/about",
+		Mount:    "/about",
+		Settings: settings,
 		NavItems: []interfaces.NavItem{
 			{
 				Title: "About",
 				SubItems: []interfaces.NavItem{
 					{
 						Title:    "About",
-						URL:      "/about",
+						URL:      "/",
 						Priority: 10,
 					},
 				},
 			},
 		},
-		Routes: setupRoutes,
+		Routes: func(app interfaces.App) {
+			setupRoutes(app, settings)
+		},
+		OnInit: func(app interfaces.App) error {
+			for _, member := range settings.Team {
+				if member.Name == "" {
+					return errors.New("team members need a name")
+				}
+			}
+			return nil
+		},
 	}
 }
 
-func setupRoutes(app interfaces.App) {
-	h := &Handler{app: app}
-	app.Handle("GET /about", h.about)
-	app.Handle("GET /about/team", h.team)
-	app.Handle("GET /about/contact", h.contact)
+func setupRoutes(app interfaces.App, settings *Settings) {
+	h := &Handler{app: app, settings: settings}
+	app.Handle("GET /", h.about)
+	app.Handle("GET /team", h.team)
+	app.Handle("GET /contact", h.contact)
 }
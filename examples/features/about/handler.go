@@ -7,7 +7,8 @@ import (
 )
 
 type Handler struct {
-	app interfaces.App
+	app      interfaces.App
+	settings *Settings
 }
 
 func (h *Handler) about(w http.ResponseWriter, r *http.Request) {
@@ -15,14 +16,7 @@ func (h *Handler) about(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) team(w http.ResponseWriter, r *http.Request) {
-	team := []struct {
-		Name string
-		Role string
-	}{
-		{"John Doe", "Lead Developer"},
-		{"Jane Smith", "Designer"},
-	}
-	h.app.RenderTemplate(w, r, "about", "team", team)
+	h.app.RenderTemplate(w, r, "about", "team", h.settings.Team)
 }
 
 func (h *Handler) contact(w http.ResponseWriter, r *http.Request) {
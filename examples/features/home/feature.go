@@ -24,12 +24,15 @@ func New() interfaces.Feature {
 			},
 		},
 		Routes: setupRoutes,
+		OnInit: func(app interfaces.App) error {
+			return app.Flags().Define("getting-started", "Getting started tips on the home page")
+		},
 	}
 }
 
 func setupRoutes(app interfaces.App) {
 	h := &Handler{app: app}
-	app.Handle("GET /", h.home)
+	app.Handle("GET /{$}", h.home)
 }
 
 // internal/features/home/handler.go
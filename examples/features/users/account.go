@@ -0,0 +1,243 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/MickDuprez/gobase/core/auth"
+	"github.com/MickDuprez/gobase/core/mail"
+)
+
+// profileMessages are the results of profile changes shown after redirecting
+// back to the profile page
+var profileMessages = map[string]string{
+	"updated":          "Your profile has been updated.",
+	"email_sent":       "Check your new email address for a link to confirm the change.",
+	"verify_sent":      "Check your email for a link to verify your address.",
+	"email_confirmed":  "Your email address has been confirmed.",
+	"password_changed": "Your password has been changed and your other sessions logged out.",
+	"password_reset":   "Please choose a new password before carrying on.",
+}
+
+type profilePage struct {
+	User    *auth.User
+	Message string
+	Errors  auth.ValidationErrors
+	// ConfirmWithPassword asks for the password to delete the account,
+	// rather than the email address
+	ConfirmWithPassword bool
+}
+
+// confirmWithPassword reports whether the user confirms deleting their
+// account with their password. Users who log in with a provider or login
+// links may never have set one, or not remember it.
+func (h *Handler) confirmWithPassword(user *auth.User) bool {
+	return user.HasPassword() && !h.app.Auth().Config().Passwordless
+}
+
+func (h *Handler) renderProfile(w http.ResponseWriter, r *http.Request, user *auth.User, errs auth.ValidationErrors) {
+	if len(errs) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+
+	h.app.RenderTemplate(w, r, "users", "profile", profilePage{
+		User:                user,
+		Message:             profileMessages[r.URL.Query().Get("message")],
+		Errors:              errs,
+		ConfirmWithPassword: h.confirmWithPassword(user),
+	})
+}
+
+// handleProfileError shows validation problems on the profile page, anything
+// else is a server error
+func (h *Handler) handleProfileError(w http.ResponseWriter, r *http.Request, user *auth.User, err error) {
+	var invalid auth.ValidationErrors
+	if errors.As(err, &invalid) {
+		h.renderProfile(w, r, user, invalid)
+		return
+	}
+	h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to update profile: %w", err))
+}
+
+func (h *Handler) updateProfile(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		h.app.Error(w, r, http.StatusInternalServerError, errors.New("user not found"))
+		return
+	}
+
+	r.ParseForm()
+	user.Name = r.FormValue("name")
+
+	if err := h.app.Auth().UpdateUser(user); err != nil {
+		h.handleProfileError(w, r, user, err)
+		return
+	}
+	h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditUserUpdate, UserID: user.ID, Email: user.Email})
+
+	http.Redirect(w, r, "/profile?message=updated", http.StatusSeeOther)
+}
+
+func (h *Handler) changeEmail(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		h.app.Error(w, r, http.StatusInternalServerError, errors.New("user not found"))
+		return
+	}
+
+	r.ParseForm()
+	email := r.FormValue("email")
+
+	token, err := h.app.Auth().ChangeEmail(user.ID, email)
+	if err != nil {
+		h.handleProfileError(w, r, user, err)
+		return
+	}
+
+	link := h.app.Auth().AbsoluteURL("/profile/email/confirm?" + url.Values{"token": {token}}.Encode())
+	err = h.app.Mailer().Send(r.Context(), &mail.Message{
+		To:      []string{email},
+		Subject: "Confirm your new email address",
+		Text:    fmt.Sprintf("Hi %s,\n\nConfirm this is your new email address by following this link:\n\n%s\n", user.Name, link),
+	})
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to send confirmation email: %w", err))
+		return
+	}
+
+	http.Redirect(w, r, "/profile?message=email_sent", http.StatusSeeOther)
+}
+
+// verifyEmail sends a link to the user's current address, so they can show
+// they own it
+func (h *Handler) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		h.app.Error(w, r, http.StatusInternalServerError, errors.New("user not found"))
+		return
+	}
+
+	token, err := h.app.Auth().VerifyEmail(user.ID)
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to start verifying email: %w", err))
+		return
+	}
+
+	link := h.app.Auth().AbsoluteURL("/profile/email/confirm?" + url.Values{"token": {token}}.Encode())
+	err = h.app.Mailer().Send(r.Context(), &mail.Message{
+		To:      []string{user.Email},
+		Subject: "Verify your email address",
+		Text:    fmt.Sprintf("Hi %s,\n\nVerify this is your email address by following this link:\n\n%s\n", user.Name, link),
+	})
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to send verification email: %w", err))
+		return
+	}
+
+	http.Redirect(w, r, "/profile?message=verify_sent", http.StatusSeeOther)
+}
+
+// confirmEmailForm asks the user to confirm the change rather than making
+// it on GET, mail scanners that prefetch links would otherwise use up the
+// token
+func (h *Handler) confirmEmailForm(w http.ResponseWriter, r *http.Request) {
+	h.app.RenderTemplate(w, r, "users", "email_confirm", struct{ Token string }{
+		Token: r.URL.Query().Get("token"),
+	})
+}
+
+func (h *Handler) confirmEmail(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	user, err := h.app.Auth().ConfirmEmailChange(r.FormValue("token"))
+	if err != nil {
+		http.Redirect(w, r, "/login?error=invalid_confirmation_link", http.StatusSeeOther)
+		return
+	}
+	h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditEmailChange, UserID: user.ID, Email: user.Email})
+
+	http.Redirect(w, r, "/profile?message=email_confirmed", http.StatusSeeOther)
+}
+
+func (h *Handler) changePassword(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		h.app.Error(w, r, http.StatusInternalServerError, errors.New("user not found"))
+		return
+	}
+
+	r.ParseForm()
+	var errs auth.ValidationErrors
+	if r.FormValue("new_password") != r.FormValue("confirm_password") {
+		errs.Add("confirm_password", "Passwords don't match")
+		h.renderProfile(w, r, user, errs)
+		return
+	}
+
+	// Keep the session making the change logged in
+	var keep string
+	if cookie, err := r.Cookie("session_id"); err == nil {
+		keep = cookie.Value
+	}
+
+	err := h.app.Auth().ChangePassword(user.ID, r.FormValue("current_password"), r.FormValue("new_password"), keep)
+	if err != nil {
+		h.handleProfileError(w, r, user, err)
+		return
+	}
+	h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditPasswordChange, UserID: user.ID, Email: user.Email})
+
+	http.Redirect(w, r, "/profile?message=password_changed", http.StatusSeeOther)
+}
+
+func (h *Handler) deactivate(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		h.app.Error(w, r, http.StatusInternalServerError, errors.New("user not found"))
+		return
+	}
+
+	if err := h.app.Auth().DisableUser(user.ID); err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to deactivate account: %w", err))
+		return
+	}
+	h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditUserDisable, UserID: user.ID, Email: user.Email})
+
+	auth.ClearSessionCookie(w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (h *Handler) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		h.app.Error(w, r, http.StatusInternalServerError, errors.New("user not found"))
+		return
+	}
+
+	// Deleting can't be undone, make sure it's really them. Users who log
+	// in without a password type their email address instead.
+	r.ParseForm()
+	var errs auth.ValidationErrors
+	if h.confirmWithPassword(user) {
+		if _, err := h.app.Auth().ValidateUser(user.Email, r.FormValue("password")); err != nil {
+			errs.Add("delete_password", "Password is incorrect")
+		}
+	} else if !strings.EqualFold(strings.TrimSpace(r.FormValue("email")), user.Email) {
+		errs.Add("delete_password", "Type your email address to confirm")
+	}
+	if len(errs) > 0 {
+		h.renderProfile(w, r, user, errs)
+		return
+	}
+
+	if err := h.app.Auth().DeleteUser(user.ID); err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to delete account: %w", err))
+		return
+	}
+	h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditUserDelete, UserID: user.ID, Email: user.Email})
+
+	auth.ClearSessionCookie(w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,64 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/MickDuprez/gobase/core/auth"
+	"github.com/MickDuprez/gobase/core/events"
+	"github.com/MickDuprez/gobase/core/interfaces"
+	"github.com/MickDuprez/gobase/core/jobs"
+	"github.com/MickDuprez/gobase/core/mail"
+)
+
+func subscribe(app interfaces.App) error {
+	h := &Handler{app: app}
+	events.Subscribe(app.Events(), h.queueWelcome)
+	return nil
+}
+
+func setupJobs(app interfaces.App) map[string]jobs.Handler {
+	h := &Handler{app: app}
+	return map[string]jobs.Handler{
+		"users.welcome": h.sendWelcome,
+	}
+}
+
+type welcomeJob struct {
+	UserID int64
+}
+
+// queueWelcome queues the welcome email for everyone who signs up, whether
+// they registered here or through a login provider. It's sent by a job so
+// a mail server that's down only delays it.
+func (h *Handler) queueWelcome(ctx context.Context, e auth.UserCreated) error {
+	_, err := h.app.Jobs().Enqueue("users.welcome", welcomeJob{UserID: e.User.ID}, &jobs.Options{
+		UniqueKey: fmt.Sprintf("users.welcome:%d", e.User.ID),
+	})
+	if err != nil && !errors.Is(err, jobs.ErrDuplicate) {
+		return fmt.Errorf("failed to queue welcome email: %w", err)
+	}
+	return nil
+}
+
+func (h *Handler) sendWelcome(ctx context.Context, job *jobs.Job) error {
+	var payload welcomeJob
+	if err := job.Decode(&payload); err != nil {
+		return err
+	}
+
+	user, err := h.app.Auth().GetUserByID(payload.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil // deleted since, nobody to welcome
+	}
+
+	return h.app.Mailer().Send(ctx, &mail.Message{
+		To:      []string{user.Email},
+		Subject: "Welcome",
+		Text:    fmt.Sprintf("Hi %s,\n\nThanks for signing up, your account is ready to use.\n", user.Name),
+	})
+}
@@ -1,11 +1,15 @@
 package users
 
-import "github.com/MickDuprez/gobase/core/interfaces"
+import (
+	"github.com/MickDuprez/gobase/core/auth"
+	"github.com/MickDuprez/gobase/core/interfaces"
+)
 
 func New() interfaces.Feature {
 	return interfaces.Feature{
-		Name: "users",
-		Path: "features/users",
+		Name:     "users",
+		Path:     "features/users",
+		Provides: []string{"login"},
 		NavItems: []interfaces.NavItem{
 			{
 				Title: "Profile",
@@ -24,24 +28,65 @@ func New() interfaces.Feature {
 			},
 		},
 		Routes: setupRoutes,
+		OnInit: onInit,
+		Jobs:   setupJobs,
+		ProfileFields: []auth.ProfileField{
+			{Key: "location", Label: "Location", Type: auth.ProfileString, MaxLength: 100},
+			{Key: "bio", Label: "Bio", Type: auth.ProfileText, MaxLength: 500},
+			{Key: "website", Label: "Website", Type: auth.ProfileURL},
+		},
+	}
+}
+
+func onInit(app interfaces.App) error {
+	if err := subscribe(app); err != nil {
+		return err
 	}
+	return scheduleTasks(app)
 }
 
 func setupRoutes(app interfaces.App) {
 	h := &Handler{app: app}
 
 	// Auth routes
-	app.Handle("GET /login", h.loginForm)
+	app.Handle("GET /login", h.loginForm).Name("users.login")
 	app.Handle("POST /login", h.login)
-	app.Handle("GET /register", h.registerForm)
+	app.Handle("GET /login/magic", h.magicLinkForm).Name("users.login.magic")
+	app.Handle("POST /login/magic", h.magicLinkLogin)
+	app.Handle("GET /register", h.registerForm).Name("users.register")
 	app.Handle("POST /register", h.register)
-	app.Handle("POST /logout", h.logout)
+	app.Handle("POST /logout", h.logout).Name("users.logout")
+
+	// The link in the confirmation email, works without being logged in
+	app.Handle("GET /profile/email/confirm", h.confirmEmailForm).Name("users.email.confirm")
+	app.Handle("POST /profile/email/confirm", h.confirmEmail)
 
 	// Protected routes
-	app.Handle("GET /profile", app.RequireAuth(h.profile))
+	profile := app.Group("/profile", app.RequireAuth)
+	profile.Handle("GET /", h.profile).Name("users.profile")
+	profile.Handle("POST /", h.updateProfile)
+	profile.Handle("POST /email/verify", h.verifyEmail).Name("users.email.verify")
+
+	// Changes only the user can make, not an admin impersonating them
+	account := profile.Group("/", auth.RefuseImpersonation)
+	account.Handle("POST /email", h.changeEmail).Name("users.email")
+	account.Handle("POST /password", h.changePassword).Name("users.password")
+	account.Handle("POST /deactivate", h.deactivate).Name("users.deactivate")
+	account.Handle("POST /delete", h.deleteAccount).Name("users.delete")
 
 	// htmx routes
-	app.Handle("GET /profile/info/add", app.RequireAuth(h.addProfileInfo))
-	app.Handle("POST /profile/info/save", app.RequireAuth(h.saveProfileInfo))
-	app.Handle("GET /profile/info/show", app.RequireAuth(h.showProfileInfo))
+	info := profile.Group("/info")
+	info.Handle("GET /add", h.addProfileInfo).Name("users.info.add")
+	info.Handle("POST /save", h.saveProfileInfo).Name("users.info.save")
+	info.Handle("GET /show", h.showProfileInfo).Name("users.info.show")
+
+	// Personal access tokens
+	profile.Handle("GET /tokens", h.tokens).Name("users.tokens")
+	tokens := account.Group("/tokens")
+	tokens.Handle("POST /", h.createToken)
+	tokens.Handle("POST /{id}/revoke", h.revokeToken).Name("users.tokens.revoke")
+
+	// API routes, only for personal access tokens
+	api := app.Group("/api", app.RequireToken)
+	api.Handle("GET /profile", auth.RequireScope("profile:read", h.apiProfile))
 }
@@ -0,0 +1,251 @@
+package users
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MickDuprez/gobase/core/auth"
+	"github.com/MickDuprez/gobase/core/interfaces"
+	"github.com/MickDuprez/gobase/core/mail"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "correct horse battery staple"
+
+// testApp registers the feature's routes on a ServeMux. Only what routing
+// and the auth middleware need is implemented, the embedded App is nil.
+type testApp struct {
+	interfaces.App
+	auth       *auth.AuthDB
+	mailer     *testMailer
+	mux        *http.ServeMux
+	prefix     string
+	middleware []interfaces.Middleware
+}
+
+// testMailer keeps the messages it's asked to send
+type testMailer struct {
+	sent []*mail.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, msg *mail.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+type testRoute struct{}
+
+func (testRoute) Name(string) interfaces.Route { return testRoute{} }
+
+func (a *testApp) Handle(pattern string, handler http.HandlerFunc) interfaces.Route {
+	for i := len(a.middleware) - 1; i >= 0; i-- {
+		handler = a.middleware[i](handler)
+	}
+	method, path, _ := strings.Cut(pattern, " ")
+	a.mux.HandleFunc(method+" "+interfaces.JoinPath(a.prefix, path), handler)
+	return testRoute{}
+}
+
+func (a *testApp) Group(prefix string, mw ...interfaces.Middleware) interfaces.Router {
+	group := *a
+	group.prefix = a.prefix + strings.TrimSuffix(prefix, "/")
+	group.middleware = append(append([]interfaces.Middleware{}, a.middleware...), mw...)
+	return &group
+}
+
+func (a *testApp) Mailer() mail.Mailer { return a.mailer }
+func (a *testApp) Auth() *auth.AuthDB  { return a.auth }
+func (a *testApp) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return a.auth.RequireAuth(next)
+}
+func (a *testApp) RequireToken(next http.HandlerFunc) http.HandlerFunc {
+	return a.auth.RequireToken(next)
+}
+
+func (a *testApp) RenderTemplate(w http.ResponseWriter, r *http.Request, feature, page string, data interface{}) error {
+	return nil
+}
+
+func (a *testApp) Error(w http.ResponseWriter, r *http.Request, status int, err error) {
+	http.Error(w, http.StatusText(status), status)
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+
+	// NewAuthDB opens data/auth.db in the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	authDB, err := auth.NewAuthDB(&auth.Config{
+		BaseURL:          "https://app.example.com",
+		Hasher:           auth.NewBcryptHasher(bcrypt.MinCost),
+		PasswordPolicy:   &auth.PasswordPolicy{MinLength: 8},
+		Passwordless:     true,
+		MagicLinkTTL:     15 * time.Minute,
+		MagicLinkLimit:   3,
+		MagicLinkWindow:  15 * time.Minute,
+		RegistrationMode: auth.RegistrationOpen,
+	})
+	if err != nil {
+		t.Fatalf("NewAuthDB: %v", err)
+	}
+	t.Cleanup(func() { authDB.Close() })
+
+	app := &testApp{auth: authDB, mailer: &testMailer{}, mux: http.NewServeMux()}
+	setupRoutes(app)
+	return app
+}
+
+// An admin impersonating a user can't take over the account, lock the user
+// out or leave themselves a way back in after the impersonation ends
+func TestImpersonationCantChangeAccount(t *testing.T) {
+	app := newTestApp(t)
+	a := app.auth
+
+	user, err := a.CreateUser("user@example.com", testPassword, "User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := a.CreateUser("admin@example.com", testPassword, "Admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userSession, err := a.CreateSession(user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := a.CreateToken(user.ID, "script", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminSession, err := a.CreateSession(admin.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impersonation, err := a.StartImpersonation(adminSession, user.ID)
+	if err != nil {
+		t.Fatalf("StartImpersonation: %v", err)
+	}
+
+	tests := []struct {
+		name, target string
+		form         url.Values
+	}{
+		{"create token", "/profile/tokens", url.Values{"name": {"backdoor"}}},
+		{"revoke token", "/profile/tokens/" + strconv.FormatInt(token.ID, 10) + "/revoke", nil},
+		{"change email", "/profile/email", url.Values{"email": {"admin+takeover@example.com"}}},
+		{"change password", "/profile/password", url.Values{
+			"current_password": {testPassword},
+			"new_password":     {"an admin's new passphrase"},
+			"confirm_password": {"an admin's new passphrase"},
+		}},
+		{"deactivate", "/profile/deactivate", nil},
+		{"delete", "/profile/delete", url.Values{"password": {testPassword}, "email": {user.Email}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.AddCookie(&http.Cookie{Name: "session_id", Value: impersonation.ID})
+			w := httptest.NewRecorder()
+			app.mux.ServeHTTP(w, r)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("status %d, want %d", w.Code, http.StatusForbidden)
+			}
+		})
+	}
+
+	// and nothing changed
+	after, err := a.GetUserByID(user.ID)
+	if err != nil || after == nil {
+		t.Fatalf("user is gone: %v", err)
+	}
+	if after.Disabled() || after.Email != user.Email || after.PasswordHash != user.PasswordHash {
+		t.Errorf("user changed: disabled %v, email %s, password changed %v",
+			after.Disabled(), after.Email, after.PasswordHash != user.PasswordHash)
+	}
+	if tokens, _ := a.ListTokens(user.ID); len(tokens) != 1 || tokens[0].ID != token.ID {
+		t.Errorf("user has tokens %v, want just the one they made", tokens)
+	}
+	if _, err := a.GetSession(userSession.ID); err != nil {
+		t.Errorf("the user's own session was revoked: %v", err)
+	}
+}
+
+// post sends a form to the app as the browser would, from host
+func post(app *testApp, host, target string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Host = host
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Forwarded-Proto", "http")
+	w := httptest.NewRecorder()
+	app.mux.ServeHTTP(w, r)
+	return w
+}
+
+// Links in emails go to the configured site, whatever Host the request that
+// sent them claimed
+func TestEmailedLinksUseBaseURL(t *testing.T) {
+	app := newTestApp(t)
+	if _, err := app.auth.CreateUser("user@example.com", testPassword, "User"); err != nil {
+		t.Fatal(err)
+	}
+
+	post(app, "evil.example", "/login", url.Values{"email": {"user@example.com"}, "magic": {"1"}})
+
+	if len(app.mailer.sent) != 1 {
+		t.Fatalf("%d emails sent, want 1", len(app.mailer.sent))
+	}
+	text := app.mailer.sent[0].Text
+	if strings.Contains(text, "evil.example") || !strings.Contains(text, "https://app.example.com/login/magic?") {
+		t.Errorf("login link isn't on the base URL:\n%s", text)
+	}
+}
+
+func TestEmailChangeLinksUseBaseURL(t *testing.T) {
+	app := newTestApp(t)
+	user, err := app.auth.CreateUser("user@example.com", testPassword, "User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := app.auth.CreateSession(user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for target, form := range map[string]url.Values{
+		"/profile/email":        {"email": {"new@example.com"}},
+		"/profile/email/verify": nil,
+	} {
+		app.mailer.sent = nil
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		r.Host = "evil.example"
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: "session_id", Value: session.ID})
+		app.mux.ServeHTTP(httptest.NewRecorder(), r)
+
+		if len(app.mailer.sent) != 1 {
+			t.Fatalf("%s: %d emails sent, want 1", target, len(app.mailer.sent))
+		}
+		text := app.mailer.sent[0].Text
+		if strings.Contains(text, "evil.example") || !strings.Contains(text, "https://app.example.com/profile/email/confirm?") {
+			t.Errorf("%s: confirmation link isn't on the base URL:\n%s", target, text)
+		}
+	}
+}
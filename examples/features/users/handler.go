@@ -1,7 +1,10 @@
 package users
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/MickDuprez/gobase/core/auth"
@@ -13,27 +16,52 @@ type Handler struct {
 }
 
 func (h *Handler) loginForm(w http.ResponseWriter, r *http.Request) {
-	h.app.RenderTemplate(w, r, "users", "login", nil)
+	data := struct {
+		Next         string
+		Passwordless bool
+	}{
+		Next:         auth.SafeRedirect(r.URL.Query().Get("next"), ""),
+		Passwordless: h.app.Auth().Config().Passwordless,
+	}
+	h.app.RenderTemplate(w, r, "users", "login", data)
 }
 
 func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	email := r.FormValue("email")
 	password := r.FormValue("password")
+	next := auth.SafeRedirect(r.FormValue("next"), "/")
+
+	// Just an email means the user wants a login link
+	if h.app.Auth().Config().Passwordless && (password == "" || r.FormValue("magic") != "") {
+		h.sendLoginLink(w, r, email, next)
+		return
+	}
 
 	user, err := h.app.Auth().ValidateUser(email, password)
 	if err != nil {
-		// Redirect back to login with error
-		http.Redirect(w, r, "/login?error=invalid_credentials", http.StatusSeeOther)
+		event := auth.AuditEvent{Type: auth.AuditLoginFailure, Email: email, Details: err.Error()}
+		if known, _ := h.app.Auth().GetUserByEmail(email); known != nil {
+			event.UserID = known.ID
+		}
+		h.app.Auth().Audit(r, event)
+
+		// Redirect back to login with error, keeping the return url
+		target := "/login?error=invalid_credentials"
+		if next != "/" {
+			target += "&next=" + url.QueryEscape(next)
+		}
+		http.Redirect(w, r, target, http.StatusSeeOther)
 		return
 	}
 
 	// Create session
 	session, err := h.app.Auth().CreateSession(user.ID, 24*time.Hour)
 	if err != nil {
-		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to create session: %w", err))
 		return
 	}
+	h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditLoginSuccess, UserID: user.ID, Email: user.Email, Details: "password"})
 
 	// Set session cookie
 	http.SetCookie(w, &http.Cookie{
@@ -46,11 +74,53 @@ func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   int(24 * time.Hour.Seconds()),
 	})
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	// An admin has asked the user to pick a new password
+	if user.MustChangePassword {
+		next = "/profile?message=password_reset"
+	}
+
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+type registerPage struct {
+	Name   string
+	Email  string
+	Invite string // invitation token, the email can't be changed when set
+	// Closed explains why there's no form when the user can't register
+	Closed string
+	Errors auth.ValidationErrors
+}
+
+// closedMessage is why someone without a usable invitation can't register,
+// empty when they can
+func (h *Handler) closedMessage(invite string) string {
+	mode := h.app.Auth().Config().RegistrationMode
+	switch {
+	case mode == auth.RegistrationClosed:
+		return "Registration is closed."
+	case invite != "":
+		return ""
+	case mode == auth.RegistrationInvite:
+		return "Registration is by invitation only."
+	}
+	return ""
 }
 
 func (h *Handler) registerForm(w http.ResponseWriter, r *http.Request) {
-	h.app.RenderTemplate(w, r, "users", "register", nil)
+	page := registerPage{Invite: r.URL.Query().Get("invite")}
+	if page.Invite != "" {
+		inv, err := h.app.Auth().GetInvitation(page.Invite)
+		if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			page.Closed = "This invitation is invalid or has expired."
+			h.app.RenderTemplate(w, r, "users", "register", page)
+			return
+		}
+		page.Email = inv.Email
+	}
+	page.Closed = h.closedMessage(page.Invite)
+
+	h.app.RenderTemplate(w, r, "users", "register", page)
 }
 
 func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
@@ -58,18 +128,51 @@ func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
 	email := r.FormValue("email")
 	password := r.FormValue("password")
 	name := r.FormValue("name")
+	invite := r.FormValue("invite")
+
+	var user *auth.User
+	var err error
+	if invite != "" {
+		user, err = h.app.Auth().CreateUserWithInvitation(invite, email, password, name)
+	} else {
+		user, err = h.app.Auth().CreateUser(email, password, name)
+	}
 
-	user, err := h.app.Auth().CreateUser(email, password, name)
-	if err != nil {
-		// Handle registration errors (e.g., duplicate email)
+	var invalid auth.ValidationErrors
+	switch {
+	case errors.As(err, &invalid):
+		// Show the form again with the problems next to each field
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		h.app.RenderTemplate(w, r, "users", "register", registerPage{
+			Name:   name,
+			Email:  email,
+			Invite: invite,
+			Errors: invalid,
+		})
+		return
+	case errors.Is(err, auth.ErrInvalidInvitation):
+		w.WriteHeader(http.StatusForbidden)
+		h.app.RenderTemplate(w, r, "users", "register", registerPage{Closed: "This invitation is invalid or has expired."})
+		return
+	case errors.Is(err, auth.ErrInvitationRequired), errors.Is(err, auth.ErrRegistrationClosed):
+		w.WriteHeader(http.StatusForbidden)
+		h.app.RenderTemplate(w, r, "users", "register", registerPage{Closed: h.closedMessage("")})
+		return
+	case err != nil:
 		http.Redirect(w, r, "/register?error=registration_failed", http.StatusSeeOther)
 		return
 	}
 
+	event := auth.AuditEvent{Type: auth.AuditUserCreate, UserID: user.ID, Email: user.Email}
+	if invite != "" {
+		event.Details = "invitation"
+	}
+	h.app.Auth().Audit(r, event)
+
 	// Auto-login after registration
 	session, err := h.app.Auth().CreateSession(user.ID, 24*time.Hour)
 	if err != nil {
-		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to create session: %w", err))
 		return
 	}
 
@@ -89,6 +192,10 @@ func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("session_id")
 	if err == nil {
+		if session, err := h.app.Auth().GetSession(cookie.Value); err == nil {
+			h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditLogout, UserID: session.UserID})
+		}
+
 		// Delete session from database
 		h.app.Auth().DeleteSession(cookie.Value)
 	}
@@ -111,65 +218,117 @@ func (h *Handler) profile(w http.ResponseWriter, r *http.Request) {
 	// Get user from context (added by auth middleware)
 	user := auth.GetUser(r)
 	if user == nil {
-		http.Error(w, "User not found", http.StatusInternalServerError)
+		h.app.Error(w, r, http.StatusInternalServerError, errors.New("user not found"))
 		return
 	}
 
-	data := struct {
-		User *auth.User
-	}{
-		User: user,
-	}
+	h.renderProfile(w, r, user, nil)
+}
+
+type profileInfo struct {
+	Fields []profileField
+	Values map[string]interface{}
+	Errors auth.ValidationErrors
+}
 
-	h.app.RenderTemplate(w, r, "users", "profile", data)
+// profileField is a registered profile field along with the form input
+// that edits it
+type profileField struct {
+	auth.ProfileField
+	Input string // an input type, or "textarea"
+}
+
+// profileFields are the fields this feature registered, the form and the
+// saved info are built from them
+func (h *Handler) profileFields() []profileField {
+	var fields []profileField
+	for _, f := range h.app.Auth().ProfileFields("users") {
+		input := "text"
+		switch f.Type {
+		case auth.ProfileText:
+			input = "textarea"
+		case auth.ProfileURL:
+			input = "url"
+		case auth.ProfileInt:
+			input = "number"
+		case auth.ProfileBool:
+			input = "checkbox"
+		}
+		fields = append(fields, profileField{ProfileField: f, Input: input})
+	}
+	return fields
 }
 
 func (h *Handler) addProfileInfo(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
 	if user == nil {
-		http.Error(w, "user not found!", http.StatusInternalServerError)
+		h.app.Error(w, r, http.StatusInternalServerError, errors.New("user not found"))
+		return
+	}
+
+	// fill the form with what's already saved
+	profile, err := h.app.Auth().GetProfile(user.ID)
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to load profile: %w", err))
 		return
 	}
 
-	h.app.RenderPartial(w, r, "users", "profile_form", nil)
+	h.app.RenderPartial(w, r, "users", "profile_form", profileInfo{
+		Fields: h.profileFields(),
+		Values: profile.Values("users"),
+	})
 }
 
 func (h *Handler) showProfileInfo(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
 	if user == nil {
-		http.Error(w, "user not found!", http.StatusInternalServerError)
+		h.app.Error(w, r, http.StatusInternalServerError, errors.New("user not found"))
 		return
 	}
 
-	// get data from database here
-
-	// set the data (hard coded for now)
-	info := map[string]string{
-		"location": "my place",
-		"bio":      "I do stuff",
-		"website":  "www.mysite.com",
+	profile, err := h.app.Auth().GetProfile(user.ID)
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to load profile: %w", err))
+		return
 	}
 
-	h.app.RenderPartial(w, r, "users", "profile_info", info)
-
+	h.app.RenderPartial(w, r, "users", "profile_info", profileInfo{
+		Fields: h.profileFields(),
+		Values: profile.Values("users"),
+	})
 }
 
 func (h *Handler) saveProfileInfo(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
 	if user == nil {
-		http.Error(w, "user not found!", http.StatusInternalServerError)
+		h.app.Error(w, r, http.StatusInternalServerError, errors.New("user not found"))
 		return
 	}
 
-	// parse the form
+	// every field is saved, one left empty, or an unticked checkbox, is
+	// cleared
 	r.ParseForm()
-	info := map[string]string{
-		"location": r.FormValue("location"),
-		"bio":      r.FormValue("bio"),
-		"website":  r.FormValue("website"),
+	fields := h.profileFields()
+	input := make(map[string]string, len(fields))
+	for _, f := range fields {
+		input[f.Key] = r.FormValue(f.Key)
 	}
 
-	// save to db here...
+	err := h.app.Auth().SaveProfile(user.ID, "users", input)
+	var invalid auth.ValidationErrors
+	if errors.As(err, &invalid) {
+		// send the form back with the problems marked
+		values := make(map[string]interface{}, len(input))
+		for k, v := range input {
+			values[k] = v
+		}
+		h.app.RenderPartial(w, r, "users", "profile_form", profileInfo{Fields: fields, Values: values, Errors: invalid})
+		return
+	}
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to save profile: %w", err))
+		return
+	}
 
-	h.app.RenderPartial(w, r, "users", "profile_info", info)
+	h.showProfileInfo(w, r)
 }
@@ -0,0 +1,88 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/MickDuprez/gobase/core/auth"
+	"github.com/MickDuprez/gobase/core/mail"
+)
+
+type magicLinkPage struct {
+	Sent  bool
+	Email string
+	Token string
+	Next  string
+}
+
+func (h *Handler) sendLoginLink(w http.ResponseWriter, r *http.Request, email, next string) {
+	user, token, err := h.app.Auth().CreateLoginLink(email)
+	if errors.Is(err, auth.ErrRateLimited) {
+		http.Redirect(w, r, "/login?error=too_many_requests", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to create login link: %w", err))
+		return
+	}
+
+	// Unknown emails get the same response so accounts can't be probed
+	if user != nil {
+		q := url.Values{"token": {token}}
+		if next != "/" {
+			q.Set("next", next)
+		}
+		link := h.app.Auth().AbsoluteURL("/login/magic?" + q.Encode())
+
+		err = h.app.Mailer().Send(r.Context(), &mail.Message{
+			To:      []string{user.Email},
+			Subject: "Your login link",
+			Text: fmt.Sprintf("Hi %s,\n\nUse this link to log in, it expires in %d minutes and can only be used once:\n\n%s\n",
+				user.Name, int(h.app.Auth().Config().MagicLinkTTL.Minutes()), link),
+		})
+		if err != nil {
+			h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to send login link: %w", err))
+			return
+		}
+	}
+
+	h.app.RenderTemplate(w, r, "users", "magic_link", magicLinkPage{Sent: true, Email: email})
+}
+
+// magicLinkForm asks the user to confirm the login rather than logging in on
+// GET, mail scanners that prefetch links would otherwise use up the token
+func (h *Handler) magicLinkForm(w http.ResponseWriter, r *http.Request) {
+	h.app.RenderTemplate(w, r, "users", "magic_link", magicLinkPage{
+		Token: r.URL.Query().Get("token"),
+		Next:  auth.SafeRedirect(r.URL.Query().Get("next"), ""),
+	})
+}
+
+func (h *Handler) magicLinkLogin(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	next := auth.SafeRedirect(r.FormValue("next"), "/")
+
+	user, err := h.app.Auth().ConsumeLoginLink(r.FormValue("token"))
+	if err != nil {
+		h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditLoginFailure, Details: "magic link: " + err.Error()})
+		http.Redirect(w, r, "/login?error=invalid_login_link", http.StatusSeeOther)
+		return
+	}
+
+	session, err := h.app.Auth().CreateSession(user.ID, 24*time.Hour)
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to create session: %w", err))
+		return
+	}
+	auth.SetSessionCookie(w, session)
+	h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditLoginSuccess, UserID: user.ID, Email: user.Email, Details: "magic link"})
+
+	if user.MustChangePassword {
+		next = "/profile?message=password_reset"
+	}
+
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
@@ -0,0 +1,29 @@
+package users
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/MickDuprez/gobase/core/interfaces"
+	"github.com/MickDuprez/gobase/core/schedule"
+)
+
+func scheduleTasks(app interfaces.App) error {
+	h := &Handler{app: app}
+
+	// 3am when it's quiet, give or take a few minutes
+	return app.Scheduler().Cron("users.sessions.cleanup", "0 3 * * *", h.cleanupSessions,
+		&schedule.Options{Jitter: 10 * time.Minute})
+}
+
+// cleanupSessions removes expired sessions, which are otherwise only
+// deleted when someone comes back with one
+func (h *Handler) cleanupSessions(ctx context.Context) error {
+	n, err := h.app.Auth().DeleteExpiredSessions()
+	if err != nil {
+		return err
+	}
+	log.Printf("Deleted %d expired sessions", n)
+	return nil
+}
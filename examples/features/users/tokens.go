@@ -0,0 +1,113 @@
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MickDuprez/gobase/core/auth"
+)
+
+type tokensPage struct {
+	User   *auth.User
+	Tokens []*auth.APIToken
+	// Secret is only set right after a token was created
+	Secret string
+}
+
+func (h *Handler) renderTokens(w http.ResponseWriter, r *http.Request, user *auth.User, secret string) {
+	tokens, err := h.app.Auth().ListTokens(user.ID)
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to load tokens: %w", err))
+		return
+	}
+
+	h.app.RenderTemplate(w, r, "users", "tokens", tokensPage{
+		User:   user,
+		Tokens: tokens,
+		Secret: secret,
+	})
+}
+
+func (h *Handler) tokens(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		h.app.Error(w, r, http.StatusInternalServerError, errors.New("user not found"))
+		return
+	}
+
+	h.renderTokens(w, r, user, "")
+}
+
+func (h *Handler) createToken(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		h.app.Error(w, r, http.StatusInternalServerError, errors.New("user not found"))
+		return
+	}
+
+	r.ParseForm()
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Redirect(w, r, "/profile/tokens?error=name_required", http.StatusSeeOther)
+		return
+	}
+	scopes := strings.Fields(strings.ReplaceAll(r.FormValue("scopes"), ",", " "))
+
+	var expiresIn time.Duration
+	if days, err := strconv.Atoi(r.FormValue("expires_days")); err == nil && days > 0 {
+		expiresIn = time.Duration(days) * 24 * time.Hour
+	}
+
+	token, secret, err := h.app.Auth().CreateToken(user.ID, name, scopes, expiresIn)
+	if err != nil {
+		h.app.Error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to create token: %w", err))
+		return
+	}
+	h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditTokenCreate, UserID: user.ID, Details: token.Prefix})
+
+	// Render rather than redirect so the secret is never put in a url
+	h.renderTokens(w, r, user, secret)
+}
+
+func (h *Handler) revokeToken(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		h.app.Error(w, r, http.StatusInternalServerError, errors.New("user not found"))
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.app.Error(w, r, http.StatusBadRequest, errors.New("Invalid token id"))
+		return
+	}
+
+	if err := h.app.Auth().RevokeToken(user.ID, id); err != nil {
+		http.Redirect(w, r, "/profile/tokens?error=revoke_failed", http.StatusSeeOther)
+		return
+	}
+	h.app.Auth().Audit(r, auth.AuditEvent{Type: auth.AuditTokenRevoke, UserID: user.ID, Details: "token " + strconv.FormatInt(id, 10)})
+
+	http.Redirect(w, r, "/profile/tokens", http.StatusSeeOther)
+}
+
+func (h *Handler) apiProfile(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		h.app.Error(w, r, http.StatusInternalServerError, errors.New("user not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         user.ID,
+		"email":      user.Email,
+		"name":       user.Name,
+		"created_at": user.CreatedAt,
+	})
+}